@@ -68,4 +68,360 @@ The Pointer (&newBook): Notice the & symbol. We pass the address of our struct s
 
 Practice Tip
 If you run this locally, you can test it using a tool like Postman or a simple curl command in your terminal:
-curl -X POST -d '{"title":"The Go Programming Language", "author":"Alan Donovan"}' http://localhost:8080/add-book
\ No newline at end of file
+curl -X POST -d '{"title":"The Go Programming Language", "author":"Alan Donovan"}' http://localhost:8080/add-book
+
+4. Telling the Client What Went Wrong (Better JSON Errors)
+The bookHandler answers every bad body with the same "Invalid JSON data". That's safe, but the person calling your API has no idea whether they forgot a comma, sent a number where you wanted a string, or misspelled a field name. The errors coming back from the decoder actually carry that information. We just need to look inside them with errors.As:
+
+Go
+import (
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io"
+    "net/http"
+    "strings"
+)
+
+// DecodeJSON decodes the request body into v. On failure it writes a 400
+// (or 413) with a message that tells the client what is wrong, and returns
+// false so the handler can simply return. Bodies are capped at 1MB unless
+// the Router already set a limit for the route (see BodyLimit in routing.go).
+func DecodeJSON(w http.ResponseWriter, r *http.Request, v any, opts ...DecodeOption) bool {
+    if _, limited := BodyLimit(r); !limited {
+        r.Body = http.MaxBytesReader(w, r.Body, 1<<20)
+    }
+    dec := json.NewDecoder(r.Body)
+    dec.DisallowUnknownFields()
+    for _, opt := range opts {
+        opt(dec)
+    }
+
+    err := dec.Decode(v)
+    if err == nil {
+        // Anything after the first JSON value is a mistake too
+        if err := dec.Decode(&struct{}{}); err != io.EOF {
+            if !WriteBodyTooLarge(w, r, err) {
+                http.Error(w, "body must only contain a single JSON value", http.StatusBadRequest)
+            }
+            return false
+        }
+        return true
+    }
+    if WriteBodyTooLarge(w, r, err) {
+        return false // over the Router's limit: its JSON 413 is sent
+    }
+
+    var syntaxErr *json.SyntaxError
+    var typeErr *json.UnmarshalTypeError
+    var maxErr *http.MaxBytesError
+    status := http.StatusBadRequest
+    var msg string
+
+    switch {
+    case errors.As(err, &syntaxErr):
+        msg = fmt.Sprintf("body contains badly-formed JSON at position %d", syntaxErr.Offset)
+    case errors.Is(err, io.ErrUnexpectedEOF):
+        msg = "body contains badly-formed JSON (it ends too early)"
+    case errors.As(err, &typeErr):
+        if m, ok := numberMessage(typeErr); ok {
+            msg = m
+        } else if typeErr.Field != "" {
+            msg = fmt.Sprintf("body contains the wrong type for field %q: got %s, want %s", typeErr.Field, typeErr.Value, typeErr.Type)
+        } else {
+            msg = fmt.Sprintf("body contains the wrong type at position %d", typeErr.Offset)
+        }
+    case strings.HasPrefix(err.Error(), "json: unknown field "):
+        // encoding/json has no error type for this one, only the message
+        msg = "body contains unknown field " + strings.TrimPrefix(err.Error(), "json: unknown field ")
+    case errors.Is(err, io.EOF):
+        msg = "body must not be empty"
+    case errors.As(err, &maxErr):
+        status = http.StatusRequestEntityTooLarge
+        msg = fmt.Sprintf("body must not be larger than %d bytes", maxErr.Limit)
+    default:
+        msg = "body could not be decoded: " + err.Error()
+    }
+
+    http.Error(w, msg, status)
+    return false
+}
+
+Now bookHandler shrinks to:
+
+Go
+func bookHandler(w http.ResponseWriter, r *http.Request) {
+    if r.Method != http.MethodPost {
+        http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+        return
+    }
+
+    var newBook Book
+    if !DecodeJSON(w, r, &newBook) {
+        return // the 400 has already been written
+    }
+
+    fmt.Printf("Received book: %s by %s\n", newBook.Title, newBook.Author)
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(map[string]string{"status": "success"})
+}
+
+And the client gets answers like:
+
+body contains badly-formed JSON at position 42
+body contains the wrong type for field "title": got number, want string
+body contains unknown field "auther"
+body must not be empty
+
+What’s happening in that code?
+errors.As: Checks if the error (or any error it wraps) has a particular type and, if so, fills in our variable. *json.SyntaxError has an Offset (the byte position of the mistake), and *json.UnmarshalTypeError knows the field name and both types.
+
+DisallowUnknownFields: By default, unknown fields are silently ignored, so a typo like "auther" produces a Book with an empty Author and no error. With this option, the typo is reported instead.
+
+The second Decode: Decode stops after the first JSON value, so a body like {"title":"a"}{"title":"b"} would be half-accepted. Decoding once more and expecting io.EOF makes sure nothing follows.
+
+The size cap: Without a limit, a client could send gigabytes for DecodeJSON to chew through. On routes where the Router has set a limit (MaxBodyBytes in routing.go), that one applies instead, so an upload route allowed 10MB isn't cut down to 1MB here. Going over the Router's limit is answered by WriteBodyTooLarge with the Router's JSON 413; only DecodeJSON's own 1MB cap ends up in the *http.MaxBytesError case below.
+
+Returning a bool: DecodeJSON has already written the response when it fails, so the handler doesn't need the error itself, only a "stop here" signal. That keeps every handler down to two lines of decoding.
+
+
+5. Error Codes that Pick Their Own Status (AppError)
+Plain text from http.Error is fine for a person reading it, but a program calling your API wants to react to errors: show "that email is taken" for a conflict, retry on "unavailable", send the user to the login page on "unauthorized". For that, every error response needs a fixed, machine-readable code, and the code and the HTTP status must always agree. If each handler picks both on its own, sooner or later some handler sends a conflict as a 400.
+
+An ErrorCode knows its own status, so a handler only chooses the code:
+
+Go
+import (
+    "encoding/json"
+    "errors"
+    "net/http"
+)
+
+// ErrorCode is the machine-readable kind of an error, sent to the client
+// next to the human-readable message.
+type ErrorCode string
+
+const (
+    CodeValidation    ErrorCode = "validation_failed"
+    CodeUnauthorized  ErrorCode = "unauthorized"
+    CodeForbidden     ErrorCode = "forbidden"
+    CodeNotFound      ErrorCode = "not_found"
+    CodeConflict      ErrorCode = "conflict"
+    CodeTooLarge      ErrorCode = "too_large"
+    CodeUnsupported   ErrorCode = "unsupported_media_type"
+    CodeUnprocessable ErrorCode = "unprocessable"
+    CodeRateLimited   ErrorCode = "rate_limited"
+    CodeUnavailable   ErrorCode = "unavailable"
+    CodeInternal      ErrorCode = "internal"
+)
+
+// HTTPStatus returns the status code that goes with c. Unknown codes are
+// treated as internal errors.
+func (c ErrorCode) HTTPStatus() int {
+    switch c {
+    case CodeValidation:
+        return http.StatusBadRequest
+    case CodeUnauthorized:
+        return http.StatusUnauthorized
+    case CodeForbidden:
+        return http.StatusForbidden
+    case CodeNotFound:
+        return http.StatusNotFound
+    case CodeConflict:
+        return http.StatusConflict
+    case CodeTooLarge:
+        return http.StatusRequestEntityTooLarge
+    case CodeUnsupported:
+        return http.StatusUnsupportedMediaType
+    case CodeUnprocessable:
+        return http.StatusUnprocessableEntity
+    case CodeRateLimited:
+        return http.StatusTooManyRequests
+    case CodeUnavailable:
+        return http.StatusServiceUnavailable
+    default:
+        return http.StatusInternalServerError
+    }
+}
+
+// AppError is an error meant for the client. Message is shown to them; Err,
+// the underlying cause, is only logged.
+type AppError struct {
+    Code    ErrorCode
+    Message string
+    Err     error
+}
+
+func (e *AppError) Error() string {
+    if e.Err != nil {
+        return string(e.Code) + ": " + e.Message + ": " + e.Err.Error()
+    }
+    return string(e.Code) + ": " + e.Message
+}
+
+func (e *AppError) Unwrap() error {
+    return e.Err
+}
+
+// WriteError sends err as a JSON error. An *AppError anywhere in the chain
+// decides the status and message; any other error becomes a 500 with a
+// generic message, so internal details never reach the client. Server
+// errors are logged through the logger from WithLogger.
+func WriteError(w http.ResponseWriter, err error, opts ...Option) {
+    var appErr *AppError
+    if !errors.As(err, &appErr) {
+        appErr = &AppError{Code: CodeInternal, Message: "internal server error", Err: err}
+    }
+    if appErr.Code.HTTPStatus() >= 500 {
+        newConfig(opts).logger.Error("request failed", "code", appErr.Code, "err", err)
+    }
+    writeAppError(w, appErr)
+}
+
+// writeAppError sends appErr's code and message, without logging anything.
+// writeJSONError in routing.go uses it too, so every JSON error has this shape.
+func writeAppError(w http.ResponseWriter, appErr *AppError) {
+    w.Header().Set("Content-Type", "application/json")
+    w.WriteHeader(appErr.Code.HTTPStatus())
+    json.NewEncoder(w).Encode(map[string]any{
+        "error": map[string]string{"code": string(appErr.Code), "message": appErr.Message},
+    })
+}
+
+Using it in a handler:
+
+Go
+func createUser(w http.ResponseWriter, r *http.Request) {
+    var u User
+    if !DecodeJSON(w, r, &u) {
+        return
+    }
+    if u.Email == "" {
+        WriteError(w, &AppError{Code: CodeValidation, Message: "email is required"})
+        return
+    }
+
+    _, err := db.Exec("INSERT INTO users (name, email) VALUES (?, ?)", u.Name, u.Email)
+    if isDuplicateKey(err) { // driver-specific, e.g. MySQL error 1062
+        WriteError(w, &AppError{Code: CodeConflict, Message: "a user with this email already exists", Err: err})
+        return
+    }
+    if err != nil {
+        WriteError(w, err) // becomes a 500, the details only go to the log
+        return
+    }
+    w.WriteHeader(http.StatusCreated)
+}
+
+The client gets a 409 with:
+
+{"error":{"code":"conflict","message":"a user with this email already exists"}}
+
+What’s happening in that code?
+A string type with constants: ErrorCode is a string underneath, so it goes into JSON as "conflict" without any extra work, but the compiler treats it as its own type. A function that takes an ErrorCode can't be handed a random string by mistake.
+
+A method on the code: HTTPStatus is the single place that maps codes to statuses. AppError has no status field at all, so there is nothing that could disagree with the code.
+
+Message vs Err: Message is written for the client. Err is the real cause (a driver error, say), which may contain table names or SQL. Unwrap lets errors.Is and errors.As look inside, and WriteError only logs it.
+
+One shape for every error: writeAppError is the part of WriteError that writes the body. The middleware in these notes answers through writeJSONError (routing.go), which passes an ErrorCode and a message to it, so a 413 from the Router and a 409 from a handler look the same to a client.
+
+errors.As in WriteError: A function deep down can return an *AppError, and callers can wrap it with fmt.Errorf("create user: %w", err) on its way up. WriteError still finds it and sends the right status.
+
+Important: Treat the codes as part of your API. Clients will write if err.code == "conflict", so once a code is out there, don't rename it. Add new codes instead. Anything that isn't an AppError is a 500 with a generic message on purpose: an error you didn't expect is one you haven't checked for secrets.
+
+
+6. Numbers That Don't Fit (Overflow Messages)
+The Movie in communicating-using-json.go has Year int, and plenty of structs use smaller types to match a database column: int32 for a year, uint8 for a quantity. What happens when a client sends "year": 99999999999? encoding/json doesn't wrap the number around, it refuses it. But the error it returns says "cannot unmarshal number 99999999999 into Go struct field Movie.year of type int32", and DecodeJSON turned that into "wrong type for field "year": got number 99999999999, want int32", which sounds as if the client sent a string. There is a worse case, too: a number going into an any field (or a map[string]any) becomes a float64, and a float64 can't hold every integer above 2^53. 12345678901234567890 arrives as 12345678901234567168, with no error at all.
+
+DecodeJSON (section 4) now looks closer at number errors, and takes options:
+
+Go
+import (
+    "encoding/json"
+    "fmt"
+    "math/big"
+    "reflect"
+    "strings"
+)
+
+// DecodeOption changes how DecodeJSON decodes.
+type DecodeOption func(*json.Decoder)
+
+// ExactNumbers decodes numbers in interface{} fields (any, map[string]any)
+// as json.Number instead of float64, which can't hold every integer
+// beyond 2^53 and would silently change it.
+func ExactNumbers() DecodeOption {
+    return func(dec *json.Decoder) { dec.UseNumber() }
+}
+
+// numberMessage explains a number that doesn't fit an integer field, e.g.
+// "value 99999999999 overflows int32 field year".
+func numberMessage(typeErr *json.UnmarshalTypeError) (string, bool) {
+    num, ok := strings.CutPrefix(typeErr.Value, "number ")
+    if !ok || typeErr.Type == nil {
+        return "", false
+    }
+    field := typeErr.Field
+    if field == "" {
+        field = fmt.Sprintf("at position %d", typeErr.Offset)
+    }
+
+    var unsigned bool
+    switch typeErr.Type.Kind() {
+    case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+    case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+        unsigned = true
+    default:
+        return "", false // a float field or a string; the generic message is fine
+    }
+
+    // 2010.5 has a fraction; 1e30 is a whole number, just too big
+    if f, _, err := big.ParseFloat(num, 10, 0, big.ToNearestEven); err == nil && !f.IsInt() {
+        return fmt.Sprintf("value %s is not a whole number, as %s field %s requires", num, typeErr.Type, field), true
+    }
+    if unsigned && strings.HasPrefix(num, "-") {
+        return fmt.Sprintf("value %s is negative, but %s field %s can't be", num, typeErr.Type, field), true
+    }
+    return fmt.Sprintf("value %s overflows %s field %s", num, typeErr.Type, field), true
+}
+
+In DecodeJSON, the *json.UnmarshalTypeError case asks numberMessage first and only falls back to the generic "wrong type" message when it says no, and every option is applied to the decoder before decoding.
+
+Using it:
+
+Go
+type Movie struct {
+    Title string `json:"title"`
+    Year  int32  `json:"year"`
+}
+
+var m Movie
+if !DecodeJSON(w, r, &m) {
+    return
+}
+
+// A handler that keeps part of the body as raw data
+var event map[string]any
+if !DecodeJSON(w, r, &event, ExactNumbers()) {
+    return
+}
+id := event["id"].(json.Number).String() // all digits, exactly as sent
+
+The client now gets answers like:
+
+value 99999999999 overflows int32 field year
+value -1 is negative, but uint8 field quantity can't be
+value 2010.5 is not a whole number, as int32 field year requires
+value 70000 overflows int16 field ratings
+
+What’s happening in that code?
+Reading UnmarshalTypeError: For a number, the decoder puts "number " and the number's text into Value, the target type into Type, and the path to the field into Field. Field names struct fields only, never array indices: a bad number anywhere in "ratings": [5, 70000] is reported as "ratings", and Offset is how far into the body the decoder had got. That's all numberMessage needs; it never has to parse the body again.
+
+Overflow or fraction: The decoder returns the same error for 99999999999 and for 2010.5 into an int32. big.ParseFloat reads the number exactly, without float64 rounding, and IsInt tells the two apart. 1e30 is a whole number, just written as a float, so it counts as an overflow.
+
+A variadic option: Adding opts ...DecodeOption keeps every existing call DecodeJSON(w, r, &v) compiling unchanged. Each option is a function that gets the *json.Decoder, so new options can be added later without changing DecodeJSON again.
+
+UseNumber: With it, numbers in interface fields are kept as json.Number, which is just the original text. You decide later whether to call Int64(), Float64() or keep the string. Typed fields (int, float64) are not affected.
+
+Important: ExactNumbers changes what you get out of any fields, so code doing event["count"].(float64) panics once it's turned on. Turn it on per handler, where you've checked that nobody makes that assertion. The range check only applies to integer fields: a float64 field accepts 1e308 without complaint, so check the range of floats (and of integers that have to be, say, between 1888 and 2100) yourself, in the handler, after decoding.
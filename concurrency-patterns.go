@@ -0,0 +1,1218 @@
+Concurrency Patterns
+====================
+
+goroutines.go shows the two basic tools: the go keyword starts a task, and channels let tasks talk to each other. select.go adds waiting on several channels at once. Real programs combine them in a handful of recurring shapes, and once you have a shape written down you can reuse it instead of re-deriving it (and its bugs) every time.
+
+Two more tools from the standard library show up in almost every pattern below:
+
+sync.WaitGroup: A counter of running goroutines. wg.Add(1) before starting one, wg.Done() when it finishes, wg.Wait() blocks until the counter is back to zero.
+
+context.Context: Carries a "stop" signal (and often a deadline) to every goroutine working on the same job. Cancelling it tells all of them to give up.
+
+
+1. Batches with a Barrier
+-------------------------
+Sometimes you want parallelism, but with checkpoints. Say you are migrating 10,000 records to a new system. You want to send 50 at a time, all 50 at once, but you don't want to start the next 50 until the current ones are all done. That gives the other system room to breathe, and if something fails you know exactly which batch it was.
+
+import (
+    "context"
+    "fmt"
+    "sync"
+)
+
+// RunBatches splits items into batches of batchSize and calls fn with one
+// batch at a time. The next batch only starts once fn has returned for the
+// current one. The first error stops all later batches.
+func RunBatches[T any](ctx context.Context, items []T, batchSize int, fn func(context.Context, []T) error) error {
+    if batchSize <= 0 {
+        return fmt.Errorf("run batches: batch size must be positive, got %d", batchSize)
+    }
+
+    for start := 0; start < len(items); start += batchSize {
+        if err := ctx.Err(); err != nil {
+            return err
+        }
+        end := min(start+batchSize, len(items))
+        if err := fn(ctx, items[start:end:end]); err != nil {
+            return fmt.Errorf("batch starting at item %d: %w", start, err)
+        }
+    }
+    return nil
+}
+
+// ForEach runs fn for every item of batch concurrently and waits until all
+// of them have finished. The first error cancels the context of the others
+// and is returned.
+func ForEach[T any](ctx context.Context, batch []T, fn func(context.Context, T) error) error {
+    ctx, cancel := context.WithCancel(ctx)
+    defer cancel()
+
+    var (
+        wg       sync.WaitGroup
+        once     sync.Once
+        firstErr error
+    )
+    for _, item := range batch {
+        wg.Add(1)
+        go func() {
+            defer wg.Done()
+            if err := fn(ctx, item); err != nil {
+                once.Do(func() {
+                    firstErr = err
+                    cancel() // tell the other items in this batch to stop
+                })
+            }
+        }()
+    }
+    wg.Wait() // the barrier: nothing continues until the whole batch is done
+    return firstErr
+}
+
+Using it, with all 50 records of a batch uploaded at once:
+
+err := RunBatches(ctx, records, 50, func(ctx context.Context, batch []Record) error {
+    return ForEach(ctx, batch, uploadRecord)
+})
+if err != nil {
+    log.Println("migration stopped:", err) // batch starting at item 350: upload 371: 503 Service Unavailable
+}
+
+Or, when the other system has a bulk endpoint, with one call per batch:
+
+err := RunBatches(ctx, records, 50, func(ctx context.Context, batch []Record) error {
+    return uploadRecords(ctx, batch)
+})
+
+What's happening in that code?
+fn gets the whole batch: RunBatches only decides what goes into a batch and when it may start. What happens inside is up to fn: run the items concurrently with ForEach, or hand all of them to one bulk call. Either way the loop doesn't move on until fn returns, which is what makes the batches run in order.
+
+wg.Wait() as the barrier: ForEach gives every item its own goroutine and only returns once wg.Wait() does, so fn, and with it the batch, isn't done while one of its items is still running.
+
+sync.Once: Several items can fail at the same moment. once.Do makes sure only the first error is kept and cancel runs only once.
+
+A cancelled context for the rest of the batch: After the first failure, the other items in the batch still running see ctx.Done(), as long as fn passes ctx on to its HTTP or database calls, and they stop early.
+
+Sub-slicing: items[start:end:end] doesn't copy anything. Each batch is a view into the original slice, and min handles the last, shorter batch. The third index caps the batch's capacity at its length, so an fn that appends to its batch gets a new array instead of overwriting the first items of the next batch.
+
+Important: Since Go 1.22 each loop iteration has its own item variable, so the goroutines can use item directly. In older Go versions they would all have seen the last item. If you're stuck on an old version, pass it as an argument: go func(item T) { ... }(item).
+
+
+2. Reporting Progress from Many Workers
+---------------------------------------
+A bulk download of 10,000 files through a worker pool can take an hour. Somebody will want to know how far along it is. The workers are the ones who know, but there are twenty of them, and they shouldn't each print a progress bar. Progress lets every worker count, and one goroutine reports.
+
+import (
+    "sync"
+    "sync/atomic"
+    "time"
+)
+
+type Snapshot struct {
+    Done  int64   `json:"done"`
+    Total int64   `json:"total"` // 0 while unknown
+    Rate  float64 `json:"rate"`  // items per second during the last interval
+}
+
+// Progress counts finished items from many goroutines and sends a Snapshot
+// to every subscriber once per interval.
+type Progress struct {
+    done  atomic.Int64
+    total atomic.Int64
+
+    mu   sync.Mutex
+    subs []chan Snapshot
+
+    stop     chan struct{}
+    stopOnce sync.Once
+    stopped  chan struct{}
+}
+
+func NewProgress(interval time.Duration) *Progress {
+    p := &Progress{stop: make(chan struct{}), stopped: make(chan struct{})}
+    go p.run(interval)
+    return p
+}
+
+// Inc records one finished item. It is safe to call from any goroutine.
+func (p *Progress) Inc() {
+    p.done.Add(1)
+}
+
+// Total sets how many items there are, once it is known.
+func (p *Progress) Total(n int64) {
+    p.total.Store(n)
+}
+
+// Subscribe returns a channel of snapshots. A subscriber that falls behind
+// only misses old snapshots, it never slows the others down. The channel is
+// closed by Stop.
+func (p *Progress) Subscribe() <-chan Snapshot {
+    ch := make(chan Snapshot, 1)
+    p.mu.Lock()
+    p.subs = append(p.subs, ch)
+    p.mu.Unlock()
+    return ch
+}
+
+// Stop sends a final snapshot and closes every subscriber channel.
+func (p *Progress) Stop() {
+    p.stopOnce.Do(func() { close(p.stop) })
+    <-p.stopped
+}
+
+func (p *Progress) run(interval time.Duration) {
+    defer close(p.stopped)
+    ticker := time.NewTicker(interval)
+    defer ticker.Stop()
+
+    last, lastTime := int64(0), time.Now()
+    snapshot := func() Snapshot {
+        now, done := time.Now(), p.done.Load()
+        s := Snapshot{Done: done, Total: p.total.Load()}
+        if elapsed := now.Sub(lastTime).Seconds(); elapsed > 0 {
+            s.Rate = float64(done-last) / elapsed
+        }
+        last, lastTime = done, now
+        return s
+    }
+
+    for {
+        select {
+        case <-ticker.C:
+            p.publish(snapshot())
+        case <-p.stop:
+            p.publish(snapshot())
+            p.mu.Lock()
+            for _, ch := range p.subs {
+                close(ch)
+            }
+            p.subs = nil
+            p.mu.Unlock()
+            return
+        }
+    }
+}
+
+// publish replaces whatever snapshot a subscriber hasn't read yet, so
+// everyone always gets the newest one. Only run sends, so after draining
+// there is always room.
+func (p *Progress) publish(s Snapshot) {
+    p.mu.Lock()
+    defer p.mu.Unlock()
+    for _, ch := range p.subs {
+        select {
+        case ch <- s:
+        default:
+            select {
+            case <-ch:
+            default:
+            }
+            ch <- s
+        }
+    }
+}
+
+Using it with the worker pool from worker-pools.go:
+
+progress := NewProgress(time.Second)
+progress.Total(int64(len(urls)))
+
+go func() {
+    for s := range progress.Subscribe() {
+        fmt.Printf("\r%d/%d (%.1f/s)", s.Done, s.Total, s.Rate)
+    }
+    fmt.Println()
+}()
+
+pool := NewWorkerPool(20, 100)
+for _, url := range urls {
+    pool.SubmitTask(url, func() error {
+        defer progress.Inc()
+        _, err := fetch(ctx, url) // goroutines.go
+        return err
+    })
+}
+pool.Close()
+progress.Stop()
+
+For an HTTP endpoint, keep the latest snapshot from one subscription and serve that:
+
+var latest atomic.Pointer[Snapshot]
+go func() {
+    for s := range progress.Subscribe() {
+        latest.Store(&s)
+    }
+}()
+
+http.HandleFunc("GET /jobs/download/progress", func(w http.ResponseWriter, r *http.Request) {
+    s := latest.Load()
+    if s == nil {
+        s = &Snapshot{} // no snapshot yet
+    }
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(s)
+})
+
+What's happening in that code?
+atomic.Int64: Inc is called by every worker, thousands of times. An atomic add is far cheaper than a mutex, and the counter is the only thing it touches.
+
+One reporter: Only the run goroutine reads the counters and talks to subscribers, once per interval. The workers never wait on a slow terminal or a slow HTTP client.
+
+Latest wins: Each subscriber channel holds one snapshot. If the subscriber hasn't read the last one yet, publish throws it away and puts in the new one. Old progress is worthless once there is newer progress, so nobody needs a bigger buffer.
+
+Rate: Items finished since the previous snapshot divided by the time since then, so it shows the current speed rather than the average since the start.
+
+Important: Call Stop when the job is done. It sends one final snapshot (so the bar ends at 100%) and closes the channels, which ends the range loops of the subscribers. Subscribe once per consumer, not once per HTTP request: a subscription lives until Stop. A Subscribe after Stop returns a channel that never receives anything.
+
+
+3. Starting Goroutines That Don't Take the Program Down (Go)
+------------------------------------------------------------
+A bare go fn() has three quiet problems. A panic inside fn crashes the whole program, and Recover middleware can't help, because it only catches panics in the handler's own goroutine. fn has no context, so it can't be cancelled and loses the request's trace and deadline. And nobody knows it's running, so at shutdown the program exits in the middle of its work.
+
+Go fixes all three in one place:
+
+import (
+    "context"
+    "runtime/debug"
+    "sync"
+)
+
+type waitGroupKey struct{}
+
+// WithWaitGroup returns a copy of ctx that makes Go register every
+// goroutine it starts with wg.
+func WithWaitGroup(ctx context.Context, wg *sync.WaitGroup) context.Context {
+    return context.WithValue(ctx, waitGroupKey{}, wg)
+}
+
+// Go runs fn in a new goroutine and passes it ctx. A panic in fn is logged
+// with its stack trace instead of crashing the program. If ctx carries a
+// WaitGroup (see WithWaitGroup), the goroutine is counted in it.
+func Go(ctx context.Context, fn func(context.Context), opts ...Option) {
+    logger := newConfig(opts).logger
+    wg, _ := ctx.Value(waitGroupKey{}).(*sync.WaitGroup)
+    if wg != nil {
+        wg.Add(1) // before the go statement, so Wait can't miss it
+    }
+
+    go func() {
+        if wg != nil {
+            defer wg.Done()
+        }
+        defer func() {
+            if p := recover(); p != nil {
+                logger.Error("goroutine panicked", "panic", p, "stack", string(debug.Stack()))
+            }
+        }()
+        fn(ctx)
+    }()
+}
+
+Using it for work that outlives a handler, with a clean shutdown:
+
+var background sync.WaitGroup
+
+func main() {
+    ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+    defer stop()
+
+    // Not derived from ctx: the signal shouldn't cancel requests that are still running
+    base := WithWaitGroup(context.Background(), &background)
+    srv := &http.Server{
+        Addr:        ":8080",
+        Handler:     mux,
+        BaseContext: func(net.Listener) context.Context { return base }, // every r.Context() carries the WaitGroup
+    }
+    go srv.ListenAndServe()
+
+    <-ctx.Done()
+    srv.Shutdown(context.Background()) // stop taking requests
+    background.Wait()                  // let the emails already started go out
+}
+
+func signup(w http.ResponseWriter, r *http.Request) {
+    // ... create the user ...
+
+    // Don't make the user wait for the mail server
+    Go(context.WithoutCancel(r.Context()), func(ctx context.Context) {
+        if err := sendWelcomeEmail(ctx, user); err != nil {
+            slog.Error("welcome email failed", "user", user.ID, "err", err)
+        }
+    })
+    w.WriteHeader(http.StatusCreated)
+}
+
+What's happening in that code?
+recover in the goroutine itself: A panic can only be recovered in the goroutine where it happened. The deferred function inside the go func is the only place that can catch it, so Go puts one there for every goroutine it starts. debug.Stack() is called inside the deferred function, where the stack still shows the line that panicked.
+
+wg.Add before go: If Add happened inside the new goroutine, Wait could run first, see zero, and return while the goroutine is about to start. Adding in the caller makes the count correct the moment Go returns.
+
+The WaitGroup travels in the context: The code that starts a goroutine deep inside a handler usually has no access to main's WaitGroup, but it does have a context. BaseContext puts the WaitGroup into every request's context once, and every Go call underneath finds it.
+
+context.WithoutCancel: r.Context() is cancelled as soon as the handler returns, which would cancel the email right away. WithoutCancel keeps its values (the WaitGroup, the trace, the request ID for the logs) but drops the cancellation and deadline. For work that should stop with the request, like a parallel query, pass r.Context() directly.
+
+Important: Go stops a panic from crashing the program, but the work in that goroutine is still lost; the log line is there so you find out and fix it. Don't use it to hide panics you expect: return an error instead. background.Wait() waits as long as the goroutines take, so give the work its own timeout (context.WithTimeout inside fn), or the shutdown could hang past the time your platform allows before killing the process.
+
+
+4. A Bounded Queue with Blocking and Non-Blocking Operations (Queue)
+--------------------------------------------------------------------
+A buffered channel is already a bounded queue: a send blocks while it's full, a receive blocks while it's empty, and select with a default case gives you the non-blocking version. WorkerPool and BufferedWriter are built on exactly that. A channel has two gaps, though. Closing it while another goroutine is still sending panics, so a shutdown needs extra locking around every send (WorkerPool's RWMutex is there for that). And there's no way to ask for "the next item, or give up when ctx is done" without writing the select at every call site.
+
+Queue wraps a ring buffer in a mutex and puts both into methods:
+
+import (
+    "context"
+    "errors"
+    "sync"
+)
+
+var ErrQueueClosed = errors.New("queue: closed")
+
+// Queue is a first-in, first-out queue that holds at most a fixed number of
+// items. It is safe for use by many goroutines at once.
+type Queue[T any] struct {
+    mu     sync.Mutex
+    items  []T // ring buffer, len(items) is the capacity
+    head   int // index of the oldest item
+    count  int
+    closed bool
+
+    // changed is closed and replaced every time an item is added or
+    // removed, which wakes every goroutine waiting in Enqueue or Dequeue.
+    changed chan struct{}
+}
+
+func NewQueue[T any](capacity int) *Queue[T] {
+    if capacity < 1 {
+        panic("queue: capacity must be at least 1")
+    }
+    return &Queue[T]{items: make([]T, capacity), changed: make(chan struct{})}
+}
+
+// Enqueue adds v to the end of the queue, waiting while it is full. It
+// returns ctx.Err() if ctx is done first, or ErrQueueClosed.
+func (q *Queue[T]) Enqueue(ctx context.Context, v T) error {
+    for {
+        q.mu.Lock()
+        if q.closed {
+            q.mu.Unlock()
+            return ErrQueueClosed
+        }
+        if q.count < len(q.items) {
+            q.push(v)
+            q.mu.Unlock()
+            return nil
+        }
+        changed := q.changed
+        q.mu.Unlock()
+
+        select {
+        case <-changed:
+        case <-ctx.Done():
+            return ctx.Err()
+        }
+    }
+}
+
+// Dequeue removes and returns the oldest item, waiting while the queue is
+// empty. After Close it still returns the remaining items, then
+// ErrQueueClosed.
+func (q *Queue[T]) Dequeue(ctx context.Context) (T, error) {
+    for {
+        q.mu.Lock()
+        if q.count > 0 {
+            v := q.pop()
+            q.mu.Unlock()
+            return v, nil
+        }
+        if q.closed {
+            q.mu.Unlock()
+            var zero T
+            return zero, ErrQueueClosed
+        }
+        changed := q.changed
+        q.mu.Unlock()
+
+        select {
+        case <-changed:
+        case <-ctx.Done():
+            var zero T
+            return zero, ctx.Err()
+        }
+    }
+}
+
+// TryEnqueue adds v if there is room and reports whether it did.
+func (q *Queue[T]) TryEnqueue(v T) bool {
+    q.mu.Lock()
+    defer q.mu.Unlock()
+    if q.closed || q.count == len(q.items) {
+        return false
+    }
+    q.push(v)
+    return true
+}
+
+// TryDequeue removes and returns the oldest item if there is one.
+func (q *Queue[T]) TryDequeue() (T, bool) {
+    q.mu.Lock()
+    defer q.mu.Unlock()
+    if q.count == 0 {
+        var zero T
+        return zero, false
+    }
+    return q.pop(), true
+}
+
+// Len returns the number of items in the queue.
+func (q *Queue[T]) Len() int {
+    q.mu.Lock()
+    defer q.mu.Unlock()
+    return q.count
+}
+
+// Close stops the queue from accepting new items. Items already in it can
+// still be dequeued.
+func (q *Queue[T]) Close() {
+    q.mu.Lock()
+    defer q.mu.Unlock()
+    if !q.closed {
+        q.closed = true
+        q.notify()
+    }
+}
+
+// push and pop must be called with q.mu held.
+func (q *Queue[T]) push(v T) {
+    q.items[(q.head+q.count)%len(q.items)] = v
+    q.count++
+    q.notify()
+}
+
+func (q *Queue[T]) pop() T {
+    var zero T
+    v := q.items[q.head]
+    q.items[q.head] = zero // don't keep a pointer to a removed item alive
+    q.head = (q.head + 1) % len(q.items)
+    q.count--
+    q.notify()
+    return v
+}
+
+func (q *Queue[T]) notify() {
+    close(q.changed)
+    q.changed = make(chan struct{})
+}
+
+Using it as a job queue that sheds load instead of piling it up:
+
+jobs := NewQueue[Job](100)
+
+// Workers
+for range 4 {
+    go func() {
+        for {
+            job, err := jobs.Dequeue(ctx)
+            if err != nil {
+                return // ErrQueueClosed after the last job, or ctx is done
+            }
+            job.Run(ctx)
+        }
+    }()
+}
+
+// Handler: answer 503 right away when the workers can't keep up
+func enqueueJob(w http.ResponseWriter, r *http.Request) {
+    if !jobs.TryEnqueue(Job{UserID: userID(r)}) {
+        w.Header().Set("Retry-After", "5")
+        http.Error(w, "too busy, try again later", http.StatusServiceUnavailable)
+        return
+    }
+    w.WriteHeader(http.StatusAccepted)
+}
+
+// Shutdown: no new jobs, the workers finish what's queued
+jobs.Close()
+
+What's happening in that code?
+Ring buffer: items never moves. head points at the oldest item and (head+count) % len(items) at the next free slot, so both ends wrap around the same fixed slice. Enqueue and Dequeue are O(1), and the queue never allocates after NewQueue. pop clears the slot it took an item from, so a queue of pointers doesn't keep removed items alive for the garbage collector.
+
+The changed channel instead of sync.Cond: sync.Cond.Wait can't be interrupted, so a goroutine waiting on it would ignore ctx. Instead, a waiter takes the current changed channel while it holds the lock, unlocks, and selects on that channel and ctx.Done(). Every push, pop and Close closes the channel, which wakes all waiters at once, and puts a fresh one in its place. A waiter that loses the race for the new item just loops and waits on the new channel.
+
+The loop around the check: Being woken only means something changed, not that there's room or an item for this goroutine. So Enqueue and Dequeue check again under the lock every time, the same reason a sync.Cond is always used in a for loop.
+
+Close drains: After Close, Enqueue and TryEnqueue fail right away, but Dequeue keeps returning the items that are left and only reports ErrQueueClosed once the queue is empty. Workers therefore finish every accepted job, and Close can be called while other goroutines are still in Enqueue, which is the panic a closed channel would cause.
+
+TryEnqueue for backpressure: A handler that blocks on a full queue holds its connection and goroutine until a worker frees a slot, and under load those pile up. TryEnqueue lets it say no right away with a 503 and a Retry-After, which a client built on ParseRetryAfter in http-client.go waits out exactly.
+
+Important: Waking every waiter on every change is simple and correct, but with hundreds of goroutines blocked on one Queue each change wakes all of them to find that only one can proceed. For a few workers and producers it doesn't matter; for many, shard the work across several queues. Queue doesn't replace the channels inside WorkerPool and BufferedWriter; reach for it when you need Close while producers are still running, context-aware waits, or Len for metrics.
+
+
+5. Decoupling Components with Typed Events (EventBus)
+-----------------------------------------------------
+When a user signs up, the signup handler has to send a welcome email, create a trial subscription and notify the sales channel. Calling all three from the handler ties it to the mailer, the billing code and the chat integration, and every new reaction means editing the handler again. With an event bus the handler only announces what happened, UserCreated, and each of the other components subscribes to the events it cares about.
+
+Events are plain structs, and the bus uses the Go type as the topic, so a handler for UserCreated receives a UserCreated and never has to type-assert or parse anything:
+
+import (
+    "context"
+    "errors"
+    "reflect"
+    "sync"
+)
+
+var ErrBusClosed = errors.New("event bus: closed")
+
+// Delivery says whether Publish waits for the handlers.
+type Delivery int
+
+const (
+    Sync  Delivery = iota // Publish returns after every handler has finished
+    Async                 // Publish returns at once; Close waits for the handlers
+)
+
+// EventBus passes events from publishers to the handlers subscribed to the
+// event's type. It is safe for use by many goroutines at once.
+type EventBus struct {
+    delivery Delivery
+
+    mu       sync.RWMutex
+    handlers map[reflect.Type][]any // each a func(context.Context, T) for its T
+    closed   bool
+    inFlight sync.WaitGroup // async handlers still running
+}
+
+func NewEventBus(delivery Delivery) *EventBus {
+    return &EventBus{delivery: delivery, handlers: make(map[reflect.Type][]any)}
+}
+
+// Subscribe registers handler for every event of type T published on bus.
+// This is a function, not a method, because methods can't have type
+// parameters.
+func Subscribe[T any](bus *EventBus, handler func(context.Context, T)) {
+    t := reflect.TypeFor[T]()
+    bus.mu.Lock()
+    defer bus.mu.Unlock()
+    bus.handlers[t] = append(bus.handlers[t], handler)
+}
+
+// Publish runs every handler subscribed to T with event, each in its own
+// goroutine. A handler that panics is logged and doesn't affect the others.
+// Async handlers get ctx without its cancellation, since the publisher's
+// request is usually over before they are.
+func Publish[T any](bus *EventBus, ctx context.Context, event T) error {
+    bus.mu.RLock()
+    if bus.closed {
+        bus.mu.RUnlock()
+        return ErrBusClosed
+    }
+    handlers := bus.handlers[reflect.TypeFor[T]()]
+
+    if bus.delivery == Async {
+        // Go registers with inFlight while we still hold the read lock,
+        // so a Close that comes after this can't miss them.
+        ctx = WithWaitGroup(context.WithoutCancel(ctx), &bus.inFlight)
+        for _, h := range handlers {
+            Go(ctx, func(ctx context.Context) { h.(func(context.Context, T))(ctx, event) })
+        }
+        bus.mu.RUnlock()
+        return nil
+    }
+    bus.mu.RUnlock()
+
+    var wg sync.WaitGroup
+    ctx = WithWaitGroup(ctx, &wg)
+    for _, h := range handlers {
+        Go(ctx, func(ctx context.Context) { h.(func(context.Context, T))(ctx, event) })
+    }
+    wg.Wait()
+    return nil
+}
+
+// Close makes every later Publish fail with ErrBusClosed and waits for the
+// async handlers that are still running.
+func (bus *EventBus) Close() {
+    bus.mu.Lock()
+    bus.closed = true
+    bus.mu.Unlock()
+    bus.inFlight.Wait()
+}
+
+Using it:
+
+type UserCreated struct {
+    UserID int64
+    Email  string
+}
+
+bus := NewEventBus(Async)
+
+Subscribe(bus, func(ctx context.Context, e UserCreated) {
+    if err := sendWelcomeEmail(ctx, e.Email); err != nil {
+        slog.Error("welcome email failed", "user", e.UserID, "err", err)
+    }
+})
+Subscribe(bus, func(ctx context.Context, e UserCreated) {
+    startTrial(ctx, e.UserID)
+})
+
+func signup(w http.ResponseWriter, r *http.Request) {
+    // ... create the user ...
+    if err := Publish(bus, r.Context(), UserCreated{UserID: id, Email: email}); err != nil {
+        slog.Warn("signup event dropped", "err", err) // shutting down
+    }
+    w.WriteHeader(http.StatusCreated)
+}
+
+// At shutdown, after srv.Shutdown
+bus.Close() // the welcome emails already started still go out
+
+What's happening in that code?
+The type is the topic: reflect.TypeFor[T]() gives a different key for every event type, so UserCreated and OrderPaid never reach each other's handlers, and a typo in a topic string can't happen because there are no strings. handlers stores the functions as any, since one map holds handlers of many types; Publish looks up T and asserts back to func(context.Context, T), which can't fail because Subscribe stored exactly that type.
+
+Functions, not methods: Go methods can't have their own type parameters, so Subscribe and Publish take the bus as their first argument instead of being bus.Subscribe and bus.Publish.
+
+Built on Go from section 3: Every handler runs in its own goroutine through Go, so a panicking handler is logged with its stack and the others carry on. WithWaitGroup tells Go which WaitGroup to count the goroutine in: a local one that Publish waits for in Sync mode, and the bus's inFlight in Async mode.
+
+Sync and Async: In Sync mode Publish returns when every handler is done, which is what you want when the caller's next step depends on them, and in tests. In Async mode Publish returns immediately. The handlers get context.WithoutCancel(ctx), for the same reason as the welcome email in section 3: the request is over, and its cancellation would stop them.
+
+Close and Publish can't cross: Publish holds the read lock from the closed check until Go has registered every async handler with inFlight. Close takes the write lock to set closed, so it waits for any Publish in the middle of that, and once it has set closed, inFlight.Wait() sees every handler that was started.
+
+Important: Async delivery is in memory only. If the process crashes or is killed before a handler has run, the event is gone with no trace, so don't use it for anything that has to happen, like charging a card; write those to a table in the same transaction as the change and process them from there. Handlers for one event run concurrently and in no particular order, so they must not depend on each other. Subscribe everything at startup, before the first Publish, and call Close only after the server has stopped taking requests, or late events are dropped with ErrBusClosed.
+
+
+6. Combining Results from Many Goroutines (ParallelReduce)
+----------------------------------------------------------
+The fast downloader in goroutines.go prints every result. Often you want one answer instead: the total bytes of all downloads, the number of lines that matched, a merged map of word counts. The usual first version has every goroutine add to a shared total behind a mutex, and with many small items the goroutines spend more time waiting for the lock than working.
+
+ParallelReduce lets each goroutine total its own share without any locking, then combines those totals:
+
+import (
+    "context"
+    "fmt"
+    "sync"
+)
+
+// ParallelReduce maps every item with mapFn and combines the results with
+// combine, using up to concurrency goroutines. combine must be associative,
+// combine(a, combine(b, c)) == combine(combine(a, b), c), and identity must
+// change nothing: combine(identity, x) == x. The result is then the same as
+// combining the mapped items one after another in order. If ctx is
+// cancelled, ParallelReduce stops early and returns ctx.Err().
+func ParallelReduce[T, R any](ctx context.Context, items []T, concurrency int, identity R, mapFn func(T) R, combine func(R, R) R) (R, error) {
+    if concurrency <= 0 {
+        return identity, fmt.Errorf("parallel reduce: concurrency must be positive, got %d", concurrency)
+    }
+    if len(items) == 0 {
+        return identity, nil
+    }
+
+    // One contiguous chunk per worker, and a slot of its own for the result,
+    // so the workers share nothing while they run.
+    workers := min(concurrency, len(items))
+    chunkSize := (len(items) + workers - 1) / workers
+    partials := make([]R, (len(items)+chunkSize-1)/chunkSize)
+    var wg sync.WaitGroup
+    for i := range partials {
+        chunk := items[i*chunkSize : min((i+1)*chunkSize, len(items))]
+        wg.Add(1)
+        go func() {
+            defer wg.Done()
+            acc := identity
+            for _, item := range chunk {
+                if ctx.Err() != nil {
+                    return
+                }
+                acc = combine(acc, mapFn(item))
+            }
+            partials[i] = acc
+        }()
+    }
+    wg.Wait()
+    if err := ctx.Err(); err != nil {
+        return identity, err
+    }
+
+    // Combine neighbours pairwise, level by level: 8 partials, then 4, 2, 1.
+    for len(partials) > 1 {
+        next := make([]R, (len(partials)+1)/2)
+        for i := range next {
+            if 2*i+1 == len(partials) {
+                next[i] = partials[2*i] // odd one out, moves up unchanged
+                continue
+            }
+            wg.Add(1)
+            go func() {
+                defer wg.Done()
+                next[i] = combine(partials[2*i], partials[2*i+1])
+            }()
+        }
+        wg.Wait()
+        partials = next
+    }
+    return partials[0], nil
+}
+
+Using it to count words across many files:
+
+counts, err := ParallelReduce(ctx, paths, 8, map[string]int{},
+    func(path string) map[string]int {
+        return countWords(path) // a fresh map per file
+    },
+    func(a, b map[string]int) map[string]int {
+        merged := make(map[string]int, max(len(a), len(b)))
+        for w, n := range a {
+            merged[w] += n
+        }
+        for w, n := range b {
+            merged[w] += n
+        }
+        return merged
+    })
+
+Or, simpler, the total size of a list of files:
+
+total, err := ParallelReduce(ctx, paths, 8, int64(0), fileSize,
+    func(a, b int64) int64 { return a + b })
+
+What's happening in that code?
+Contiguous chunks: The items are cut into one chunk per goroutine, in order, and each goroutine writes only its own element of partials. Different elements of a slice are different memory, so there's no lock and no race, and wg.Wait() makes all the writes visible before they're read.
+
+Combining in a tree: The partial results are combined pairwise, 0 with 1, 2 with 3, and so on, with each level's pairs running at the same time. With 8 workers that's 3 levels instead of 7 combines one after another, which matters when combine is expensive, like merging two large maps.
+
+Why associative is enough: Chunks are combined only with their neighbours, left before right, so the order of the items is kept. That's why combine doesn't have to be commutative: joining strings works and gives them in their original order. It does have to be associative, because the tree groups the items differently than a plain loop would: (a+b)+(c+d) instead of ((a+b)+c)+d. Addition, max, merging maps and joining strings are associative; subtraction and averaging are not. To average, reduce to a sum and a count and divide at the end.
+
+A real identity: Every chunk starts from identity, so it ends up in the result once per chunk. 0 for a sum and an empty map for a merge are harmless; a starting value like 100 would be added 8 times.
+
+Cancellation: The workers check ctx between items and stop; a cancelled reduction returns ctx.Err() and no partial result, since it would be silently wrong.
+
+Important: combine must not change its arguments in place. Every chunk starts from the same identity value, so a combine that copies b into a and returns a would write every chunk's words into that one empty map, from 8 goroutines at once. The map merge above builds a new map each time, which is safe. mapFn runs in the workers' goroutines, and a panic in it (or in combine) crashes the program, as with any bare go statement; recover inside mapFn if an item can panic. For work that's mostly waiting, like downloads, use the worker pool instead, and reduce its results afterwards.
+
+
+7. Panics as Errors on a Channel (SafeGo)
+-----------------------------------------
+The fast downloader in goroutines.go starts three goroutines and then reads exactly three values from the channel. If one download panics, the whole program crashes. Recovering inside download is the obvious fix, but then the goroutine ends without sending anything, and main waits forever for its third value. Go from section 3 has the same gap: it logs the panic, but the caller never hears about it.
+
+SafeGo turns the panic into a value on the channel, so every goroutine reports back exactly once, whatever happens:
+
+import (
+    "fmt"
+    "runtime/debug"
+)
+
+// PanicError is the error SafeGo sends for a goroutine that panicked.
+type PanicError struct {
+    Value any    // what was passed to panic
+    Stack []byte // where it happened
+}
+
+func (e *PanicError) Error() string {
+    return fmt.Sprintf("goroutine panicked: %v", e.Value)
+}
+
+// Unwrap returns the panic value if it was an error, e.g. a runtime error,
+// so errors.Is and errors.As can look inside.
+func (e *PanicError) Unwrap() error {
+    err, _ := e.Value.(error)
+    return err
+}
+
+// SafeGo runs fn in a new goroutine and sends exactly one value to errCh:
+// fn's error, nil if it succeeded, or a *PanicError if it panicked.
+func SafeGo(fn func() error, errCh chan<- error) {
+    go func() {
+        var err error
+        defer func() {
+            if p := recover(); p != nil {
+                err = &PanicError{Value: p, Stack: debug.Stack()}
+            }
+            errCh <- err
+        }()
+        err = fn()
+    }()
+}
+
+The downloader with SafeGo:
+
+func download(site string) error {
+    fmt.Println("Starting download from:", site)
+    time.Sleep(2 * time.Second) // simulate a slow download
+    return nil
+}
+
+func main() {
+    sites := []string{"Google.com", "Amazon.com", "Github.com"}
+    errCh := make(chan error, len(sites))
+    for _, site := range sites {
+        SafeGo(func() error { return download(site) }, errCh)
+    }
+
+    for range sites {
+        err := <-errCh
+        var pe *PanicError
+        switch {
+        case errors.As(err, &pe):
+            log.Printf("download panicked: %v\n%s", pe.Value, pe.Stack)
+        case err != nil:
+            log.Println("download failed:", err)
+        }
+    }
+    fmt.Println("All downloads finished!")
+}
+
+What's happening in that code?
+One send in the deferred function: The send to errCh happens in the defer, so it runs on every way out of the goroutine: fn returning nil, returning an error, or panicking. Reading len(sites) values is therefore always right, and main can't wait for a value that never comes.
+
+The stack is taken in the defer: debug.Stack() is called inside the deferred function, while the stack still shows the line that panicked, as in Go from section 3. It's kept in its own field, so Error() stays one short line and the stack goes to the log only where you print it.
+
+Unwrap: Many panics are runtime errors, like an index out of range or a nil map. Unwrap returns those, so errors.As into a runtime.Error works on the PanicError too.
+
+A buffered channel: errCh has room for every result. If main gives up early, say after the first error, the other goroutines can still send and finish, instead of blocking forever on a send nobody receives.
+
+Important: SafeGo keeps a panic from crashing the program, but the work that goroutine was doing is still lost, and the data it was changing may be half-updated. Use it to report the failure, not to ignore it. A goroutine that ends through runtime.Goexit, which is what t.FailNow does in a test, sends nil, since nothing panicked. For goroutines that must be counted at shutdown and don't return a result, Go from section 3 is still the better fit.
+
+
+8. Shutting Down in the Right Order (ShutdownManager)
+-----------------------------------------------------
+The shutdown in section 3 is two lines: srv.Shutdown, then background.Wait. A real service has more parts: the HTTP server, a worker pool, the EventBus from section 5, a metrics flusher, the database. The order matters. Close the database first and the requests still being answered fail with "sql: database is closed"; stop the event bus before the server and the last signups lose their welcome email. Written out by hand in main, that order is easy to get wrong the next time someone adds a component.
+
+ShutdownManager lets each component say what it still needs while it stops, and works out the order from that:
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "slices"
+    "strings"
+    "sync"
+)
+
+// ShutdownManager stops the parts of a program in an order that respects
+// their dependencies: a component stops only after everything that depends
+// on it has stopped.
+type ShutdownManager struct {
+    cfg        config
+    mu         sync.Mutex
+    components map[string]*component
+    order      []string // registration order, for a stable shutdown order
+
+    once sync.Once
+    err  error
+}
+
+type component struct {
+    dependsOn []string
+    stop      func(context.Context) error
+}
+
+func NewShutdownManager(opts ...Option) *ShutdownManager {
+    return &ShutdownManager{cfg: newConfig(opts), components: make(map[string]*component)}
+}
+
+// OnShutdown registers stop as the way to shut down name. dependsOn lists
+// the components name still needs while it stops; they are stopped after
+// it. A dependency may be registered later. A name registered twice, or a
+// dependency that would make a cycle, is an error and nothing is added.
+func (m *ShutdownManager) OnShutdown(name string, dependsOn []string, stop func(context.Context) error) error {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    if _, ok := m.components[name]; ok {
+        return fmt.Errorf("shutdown: %q is already registered", name)
+    }
+
+    m.components[name] = &component{dependsOn: dependsOn, stop: stop}
+    if cycle := m.findCycle(name, []string{name}); cycle != nil {
+        delete(m.components, name)
+        return fmt.Errorf("shutdown: dependency cycle %s", strings.Join(cycle, " -> "))
+    }
+    m.order = append(m.order, name)
+    return nil
+}
+
+// findCycle follows dependencies from the last name in path and returns the
+// path once it leads back to start, or nil if it never does.
+func (m *ShutdownManager) findCycle(start string, path []string) []string {
+    c := m.components[path[len(path)-1]]
+    if c == nil {
+        return nil // not registered yet, so nothing to follow
+    }
+    for _, dep := range c.dependsOn {
+        if dep == start {
+            return append(path, dep)
+        }
+        if slices.Contains(path, dep) {
+            continue // a cycle that doesn't involve start was caught when it was added
+        }
+        if cycle := m.findCycle(start, append(path, dep)); cycle != nil {
+            return cycle
+        }
+    }
+    return nil
+}
+
+// Shutdown stops every component, dependents first, and returns all their
+// errors joined. A failing component doesn't stop the others from being
+// shut down. Calling Shutdown again returns the first result.
+func (m *ShutdownManager) Shutdown(ctx context.Context) error {
+    m.once.Do(func() { m.err = m.shutdown(ctx) })
+    return m.err
+}
+
+func (m *ShutdownManager) shutdown(ctx context.Context) error {
+    m.mu.Lock()
+    order, components := slices.Clone(m.order), m.components
+    m.mu.Unlock()
+
+    // dependents[x] counts the components that need x and haven't stopped yet
+    dependents := make(map[string]int)
+    var errs []error
+    for _, name := range order {
+        for _, dep := range components[name].dependsOn {
+            if _, ok := components[dep]; !ok {
+                errs = append(errs, fmt.Errorf("shutdown: %q depends on %q, which was never registered", name, dep))
+                continue
+            }
+            dependents[dep]++
+        }
+    }
+
+    stopped := make(map[string]bool)
+    for len(stopped) < len(order) {
+        // The most recently registered component nothing needs anymore goes
+        // next, the same order defer would use. There is always one, since
+        // OnShutdown doesn't allow cycles.
+        var next string
+        for _, name := range slices.Backward(order) {
+            if !stopped[name] && dependents[name] == 0 {
+                next = name
+                break
+            }
+        }
+
+        c := components[next]
+        m.cfg.logger.Info("shutting down", "component", next)
+        if err := c.stop(ctx); err != nil {
+            errs = append(errs, fmt.Errorf("shutdown %s: %w", next, err))
+        }
+        stopped[next] = true
+        for _, dep := range c.dependsOn {
+            if _, ok := components[dep]; ok {
+                dependents[dep]--
+            }
+        }
+    }
+    return errors.Join(errs...)
+}
+
+Using it:
+
+func main() {
+    ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+    defer stop()
+
+    db := mustOpenDB()
+    bus := NewEventBus(Async)
+    srv := &http.Server{Addr: ":8080", Handler: mux}
+
+    shutdown := NewShutdownManager()
+    shutdown.OnShutdown("db", nil, func(context.Context) error { return db.Close() })
+    shutdown.OnShutdown("events", []string{"db"}, func(context.Context) error { bus.Close(); return nil })
+    shutdown.OnShutdown("http", []string{"db", "events"}, srv.Shutdown)
+
+    go srv.ListenAndServe()
+    <-ctx.Done()
+
+    // Kubernetes waits 30s after SIGTERM before it kills the pod
+    sctx, cancel := context.WithTimeout(context.Background(), 25*time.Second)
+    defer cancel()
+    if err := shutdown.Shutdown(sctx); err != nil {
+        log.Println(err)
+    }
+}
+
+The log shows http, then events, then db.
+
+What's happening in that code?
+Dependencies point at what you still need: "http" depends on "db" because requests use the database until the server has stopped. So http stops first and db last: a component only stops once everything that depends on it has stopped.
+
+Cycles are caught when they're made: findCycle follows the dependencies of the new component and fails if they lead back to it. "a needs b, b needs c, c needs a" has no valid order, and it's a mistake in the code, so OnShutdown returns "shutdown: dependency cycle c -> a -> b -> c" at startup, where it's found on the first run, instead of at the next deploy's shutdown. Since every cycle is refused the moment it would close, the graph never contains one, and findCycle only needs to look for cycles through the new name.
+
+Dependencies registered later: "http" may name "db" before "db" is registered, so the order of the OnShutdown calls doesn't matter. A name that's never registered at all is reported by Shutdown as an error; the other components are still stopped.
+
+Choosing the next component: dependents counts, for each component, how many others still need it. Shutdown repeatedly picks a component whose count is zero, stops it, and lowers the count of everything it depended on. Among several candidates it takes the one registered last, the same order several defers would run in, so the result is the same on every run.
+
+Every component is stopped: A failing stop function doesn't end the shutdown. Its error is collected with errors.Join, and the database is still closed after the server reported an error.
+
+Important: All components share the one ctx given to Shutdown, so its timeout is for the whole shutdown, not for each step. If the server takes 24 of the 25 seconds, the database gets what's left. Stop functions should return when ctx is done, as http.Server.Shutdown does. The stop functions run one after another, never in parallel, so keep each one bounded. Register everything before Shutdown; a component added afterwards is never stopped.
+
+
+9. Waiting for Things to Calm Down (Debounce)
+---------------------------------------------
+ConfigWatcher in configuration.go polls the file every couple of seconds. Switch to file events (fsnotify) and a single save can arrive as three events, because editors often write in steps (truncate, write, rename). A reload after each one wastes work at best and reads a half-written file at worst. What you want is "reload once, when the changes have stopped". That's a debounce: every event restarts a short wait, and only when the wait runs out does the work happen.
+
+import (
+    "sync"
+    "time"
+)
+
+// Debounce returns a trigger that runs fn once no trigger has come in for
+// wait. A burst of triggers runs fn once, wait after the last of them. stop
+// ends the background goroutine and drops a run that is still pending.
+func Debounce(wait time.Duration, fn func(), opts ...Option) (trigger func(), stop func()) {
+    clk := newConfig(opts).clock
+    kick := make(chan struct{}, 1)
+    done := make(chan struct{})
+
+    go func() {
+        var fire <-chan time.Time // nil while nothing is pending; a nil channel never fires
+        for {
+            select {
+            case <-kick:
+                fire = clk.After(wait) // restart the wait; the old channel is simply never read
+            case <-fire:
+                fire = nil
+                fn()
+            case <-done:
+                return
+            }
+        }
+    }()
+
+    trigger = func() {
+        select {
+        case kick <- struct{}{}:
+        default: // a trigger is already queued, and it restarts the wait just the same
+        }
+    }
+    var once sync.Once
+    stop = func() { once.Do(func() { close(done) }) }
+    return trigger, stop
+}
+
+Using it:
+
+reload, stop := Debounce(200*time.Millisecond, func() {
+    if err := loadConfig(path); err != nil {
+        slog.Error("reload failed", "err", err)
+    }
+})
+defer stop()
+
+for range watcher.Events {
+    reload() // three events within 200ms cause one reload
+}
+
+What's happening in that code?
+One goroutine owns the timer: Triggers only send into kick, and the goroutine is the only one that touches fire. No mutex is needed, and fn never runs twice at the same time.
+
+The nil channel: While nothing is pending, fire is nil, and a receive from a nil channel blocks forever, so that case of the select is switched off until the next trigger.
+
+A full kick channel: kick holds one value. If a trigger is already waiting there, another one would mean exactly the same thing, so the default case drops it instead of blocking the caller.
+
+The clock: The wait goes through clk.After, with the clock from WithClock, so a test can trigger, Advance a clock.Fake past wait and check that fn ran, without sleeping (see testing-helpers.go).
+
+Important: fn runs on the debounce goroutine, so a slow fn delays the next one; triggers that arrive meanwhile are kept and start a new wait afterwards. Calling trigger after stop does nothing.
+
+
+10. Limiting How Often Something Runs (RateLimiter)
+---------------------------------------------------
+A partner API allows 10 requests per second, and sends 429 Too Many Requests (and sometimes a ban) to anyone who sends more. Twenty workers from a WorkerPool will happily send 200. A RateLimiter sits in front of the calls and hands out permission at the allowed rate.
+
+It's a token bucket: the bucket holds up to burst tokens, every call takes one, and tokens flow back in at rate per second. A quiet period fills the bucket, so a short burst after it goes through at once; a long burst is slowed down to rate.
+
+import (
+    "context"
+    "sync"
+    "time"
+)
+
+// RateLimiter allows burst events at once and rate events per second on
+// average after that. It's safe for concurrent use.
+type RateLimiter struct {
+    cfg    config
+    mu     sync.Mutex
+    rate   float64 // tokens per second
+    burst  float64
+    tokens float64
+    last   time.Time // when tokens was last brought up to date
+}
+
+// NewRateLimiter starts with a full bucket. rate must be positive.
+func NewRateLimiter(rate float64, burst int, opts ...Option) *RateLimiter {
+    if rate <= 0 {
+        panic("rate limiter: non-positive rate")
+    }
+    cfg := newConfig(opts)
+    return &RateLimiter{cfg: cfg, rate: rate, burst: float64(burst), tokens: float64(burst), last: cfg.clock.Now()}
+}
+
+// Allow takes a token if there is one, without waiting.
+func (l *RateLimiter) Allow() bool {
+    l.mu.Lock()
+    defer l.mu.Unlock()
+    l.refill()
+    if l.tokens < 1 {
+        return false
+    }
+    l.tokens--
+    return true
+}
+
+// Wait blocks until it gets a token, or returns ctx's error if ctx is done
+// first.
+func (l *RateLimiter) Wait(ctx context.Context) error {
+    for {
+        l.mu.Lock()
+        l.refill()
+        if l.tokens >= 1 {
+            l.tokens--
+            l.mu.Unlock()
+            return nil
+        }
+        // How long until the missing part of a token has flowed back in
+        delay := time.Duration((1 - l.tokens) / l.rate * float64(time.Second))
+        l.mu.Unlock()
+
+        select {
+        case <-l.cfg.clock.After(delay):
+            // another goroutine may have taken it first, so check again
+        case <-ctx.Done():
+            return ctx.Err()
+        }
+    }
+}
+
+func (l *RateLimiter) refill() {
+    now := l.cfg.clock.Now()
+    l.tokens = min(l.burst, l.tokens+now.Sub(l.last).Seconds()*l.rate)
+    l.last = now
+}
+
+Using it, shared by every worker that calls the partner:
+
+limiter := NewRateLimiter(10, 10)
+
+pool.SubmitTask("order "+order.ID, func() error {
+    if err := limiter.Wait(ctx); err != nil {
+        return err
+    }
+    return callPartner(ctx, order)
+})
+
+And in a handler, where waiting isn't an option:
+
+if !limiter.Allow() {
+    writeJSONError(w, CodeRateLimited, "too many requests, slow down")
+    return
+}
+
+What's happening in that code?
+No goroutine refilling the bucket: Nothing adds tokens on a timer. refill works out how many would have flowed in since last, from the clock, whenever someone asks. An idle limiter costs nothing.
+
+min(l.burst, ...): A limiter that sat idle for an hour doesn't save up 36,000 tokens. The bucket is full at burst, and that's the most that can go through at once.
+
+Wait computes its delay: With 0.4 tokens in the bucket at 10 per second, the next whole token is 60ms away, so Wait sleeps exactly that long instead of polling. After waking it checks again, because another goroutine may have been faster.
+
+The clock: Now and After come from the clock passed with WithClock, so a test can empty the bucket, Advance a clock.Fake by 100ms and see exactly one more Allow succeed (see testing-helpers.go).
+
+Important: The limit is per RateLimiter, and so per process. Three instances of the service each allow 10 per second, 30 together, so divide the partner's limit by the number of instances, or keep the count somewhere they share, such as Redis. burst should be at least 1, or nothing is ever allowed.
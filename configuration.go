@@ -0,0 +1,386 @@
+Configuring Go Applications
+===========================
+
+Hardcoding values like "root:password@tcp(localhost:3306)/myapp" is fine while you are learning, but a real program needs different settings on your laptop, in testing, and in production. The usual answer is to read configuration from the environment, so the same binary runs everywhere and only the settings change.
+
+
+1. Typed Environment Variables
+------------------------------
+The os package gives you environment variables as plain strings:
+
+port := os.Getenv("PORT") // "" if it isn't set
+
+Very quickly you need numbers, booleans, durations, and lists, and every one of them needs a conversion plus an error check. Generics let us write that once. Env[T] parses a variable into whatever type you ask for and falls back to a default. MustEnv[T] is for settings the program cannot start without, so it panics instead.
+
+import (
+    "fmt"
+    "os"
+    "strconv"
+    "strings"
+    "time"
+
+    "myapp/applog"
+)
+
+// Env returns the parsed value of key, or def if it is unset or invalid.
+func Env[T any](key string, def T) T {
+    v, ok, err := parseEnv[T](key)
+    if err != nil {
+        applog.Default().Warn("invalid environment variable, using default", "key", key, "err", err)
+        return def
+    }
+    if !ok {
+        return def
+    }
+    return v
+}
+
+// MustEnv returns the parsed value of key and panics if it is unset or invalid.
+func MustEnv[T any](key string) T {
+    v, ok, err := parseEnv[T](key)
+    if err != nil {
+        panic(err)
+    }
+    if !ok {
+        panic(fmt.Sprintf("env %s: required variable is not set", key))
+    }
+    return v
+}
+
+func parseEnv[T any](key string) (T, bool, error) {
+    var v T
+    raw, ok := os.LookupEnv(key)
+    if !ok || raw == "" {
+        return v, false, nil
+    }
+    if err := parseInto(&v, raw); err != nil {
+        return v, false, fmt.Errorf("env %s=%q: %w", key, raw, err)
+    }
+    return v, true, nil
+}
+
+func parseInto(dst any, raw string) error {
+    var err error
+    switch p := dst.(type) {
+    case *string:
+        *p = raw
+    case *int:
+        *p, err = strconv.Atoi(raw)
+    case *int64:
+        *p, err = strconv.ParseInt(raw, 10, 64)
+    case *float64:
+        *p, err = strconv.ParseFloat(raw, 64)
+    case *bool:
+        *p, err = strconv.ParseBool(raw)
+    case *time.Duration:
+        *p, err = time.ParseDuration(raw)
+    case *[]string:
+        *p = splitList(raw)
+    case *[]int:
+        var out []int
+        for _, s := range splitList(raw) {
+            n, convErr := strconv.Atoi(s)
+            if convErr != nil {
+                return fmt.Errorf("item %q: %w", s, convErr)
+            }
+            out = append(out, n)
+        }
+        *p = out
+    default:
+        return fmt.Errorf("unsupported type %T", dst)
+    }
+    return err
+}
+
+// splitList turns "a, b,,c" into ["a", "b", "c"].
+func splitList(raw string) []string {
+    var out []string
+    for _, s := range strings.Split(raw, ",") {
+        if s = strings.TrimSpace(s); s != "" {
+            out = append(out, s)
+        }
+    }
+    return out
+}
+
+Using it:
+
+port := Env("PORT", 8080)                         // int
+debug := Env("DEBUG", false)                      // bool
+timeout := Env("REQUEST_TIMEOUT", 5*time.Second)  // "250ms", "1m30s", ...
+origins := Env("ALLOWED_ORIGINS", []string{"*"})  // "a.com, b.com"
+dsn := MustEnv[string]("DATABASE_URL")            // no sensible default, so it's required
+
+What's happening in that code?
+Type inference: Env("PORT", 8080) works out that T is int from the default value. MustEnv has no default to look at, so you spell the type out: MustEnv[string](...).
+
+The type switch: Go's generics can't say "T must be one of these types and parse each one differently", so parseInto switches on a pointer to the value. Each case knows how to convert the raw string.
+
+Clear errors: Every error names the variable and the bad value, e.g. env REQUEST_TIMEOUT="5": time: missing unit in duration "5". That tells whoever deployed the program exactly what to fix.
+
+Important: An unset variable and an empty one ("PORT=") are treated the same way. Both mean "use the default". A variable that is set but cannot be parsed also falls back to the default, but Env logs a warning through applog.Default() first (see logging.go), so the typo doesn't go unnoticed and ends up wherever the rest of the program's logs go.
+
+
+2. Reloading a Config File Without a Restart
+--------------------------------------------
+Environment variables are read once at startup. To change one you restart the program. For settings you tweak often, like the log level or a feature flag, a config file that the server reloads by itself is more convenient:
+
+{
+  "port": 8080,
+  "log_level": "info",
+  "max_body_bytes": 1048576,
+  "features": {"new_checkout": false}
+}
+
+Reloading has two dangers. The first is half-written or broken files: if you save a typo, the server must keep running on the old config instead of crashing or using garbage. The second is concurrency: hundreds of handlers read the config while the reloader replaces it, and they must never see a half-updated value.
+
+First, a loader that validates as well as parses:
+
+import (
+    "encoding/json"
+    "fmt"
+    "os"
+)
+
+type Config struct {
+    Port         int             `json:"port"`
+    LogLevel     string          `json:"log_level"`
+    MaxBodyBytes int64           `json:"max_body_bytes"`
+    Features     map[string]bool `json:"features"`
+}
+
+// Validate catches mistakes that would still parse as valid JSON.
+func (c *Config) Validate() error {
+    if c.Port < 1 || c.Port > 65535 {
+        return fmt.Errorf("port %d out of range", c.Port)
+    }
+    switch c.LogLevel {
+    case "debug", "info", "warn", "error":
+    default:
+        return fmt.Errorf("unknown log_level %q", c.LogLevel)
+    }
+    if c.MaxBodyBytes < 0 {
+        return fmt.Errorf("max_body_bytes must not be negative")
+    }
+    return nil
+}
+
+// LoadConfig reads, parses and validates a JSON config file.
+func LoadConfig(path string) (*Config, error) {
+    data, err := os.ReadFile(path)
+    if err != nil {
+        return nil, err
+    }
+
+    cfg := &Config{LogLevel: "info"} // defaults for fields the file leaves out
+    if err := json.Unmarshal(StripJSONComments(data), cfg); err != nil {
+        return nil, fmt.Errorf("config %s: %w", path, err)
+    }
+    if err := cfg.Validate(); err != nil {
+        return nil, fmt.Errorf("config %s: %w", path, err)
+    }
+    return cfg, nil
+}
+
+Then the watcher. It takes the same WithLogger option as the other helpers (see logging.go):
+
+import (
+    "context"
+    "os"
+    "slices"
+    "sync"
+    "sync/atomic"
+    "time"
+)
+
+// ConfigWatcher keeps the current Config and reloads it when the file changes.
+type ConfigWatcher struct {
+    path    string
+    cfg     config
+    current atomic.Pointer[Config]
+
+    mu        sync.Mutex // guards listeners
+    listeners []func(*Config)
+
+    lastMod  time.Time
+    lastSize int64
+}
+
+// NewConfigWatcher loads the file once. It fails if that first load fails,
+// because there is no older config to fall back on.
+func NewConfigWatcher(path string, opts ...Option) (*ConfigWatcher, error) {
+    w := &ConfigWatcher{path: path, cfg: newConfig(opts)}
+    info, err := os.Stat(path)
+    if err != nil {
+        return nil, err
+    }
+    cfg, err := LoadConfig(path)
+    if err != nil {
+        return nil, err
+    }
+    w.current.Store(cfg)
+    w.lastMod, w.lastSize = info.ModTime(), info.Size()
+    return w, nil
+}
+
+// Current returns the config in use right now. Safe from any goroutine.
+// Treat the result as read-only: other goroutines share it.
+func (w *ConfigWatcher) Current() *Config {
+    return w.current.Load()
+}
+
+// OnReload registers fn to be called with every new config.
+func (w *ConfigWatcher) OnReload(fn func(*Config)) {
+    w.mu.Lock()
+    defer w.mu.Unlock()
+    w.listeners = append(w.listeners, fn)
+}
+
+// Watch checks the file every interval until ctx is cancelled.
+func (w *ConfigWatcher) Watch(ctx context.Context, interval time.Duration) {
+    ticker := time.NewTicker(interval)
+    defer ticker.Stop()
+
+    for {
+        select {
+        case <-ctx.Done():
+            return
+        case <-ticker.C:
+            w.check()
+        }
+    }
+}
+
+func (w *ConfigWatcher) check() {
+    info, err := os.Stat(w.path)
+    if err != nil {
+        w.cfg.logger.Warn("config file unavailable, keeping current config", "path", w.path, "err", err)
+        return
+    }
+
+    // Only Watch calls check, so lastMod and lastSize need no locking
+    if info.ModTime().Equal(w.lastMod) && info.Size() == w.lastSize {
+        return // unchanged
+    }
+    w.lastMod, w.lastSize = info.ModTime(), info.Size()
+
+    cfg, err := LoadConfig(w.path)
+    if err != nil {
+        // A half-saved or broken edit must not replace a config that works
+        w.cfg.logger.Error("config reload rejected, keeping current config", "path", w.path, "err", err)
+        return
+    }
+
+    w.current.Store(cfg)
+    w.cfg.logger.Info("config reloaded", "path", w.path)
+
+    w.mu.Lock()
+    listeners := slices.Clone(w.listeners)
+    w.mu.Unlock()
+    for _, fn := range listeners { // called without the lock, so fn may call OnReload
+        fn(cfg)
+    }
+}
+
+Using it:
+
+watcher, err := NewConfigWatcher("config.json")
+if err != nil {
+    log.Fatal(err) // no usable config at startup: refuse to start
+}
+watcher.OnReload(func(cfg *Config) {
+    setLogLevel(cfg.LogLevel)
+})
+go watcher.Watch(ctx, 2*time.Second)
+
+func checkoutHandler(w http.ResponseWriter, r *http.Request) {
+    if watcher.Current().Features["new_checkout"] {
+        ...
+    }
+}
+
+What's happening in that code?
+atomic.Pointer[Config]: Store swaps in the new config in a single step, and Load always returns either the complete old config or the complete new one. Readers take no locks at all, which matters because every request calls Current().
+
+Validate before Store: LoadConfig fails on syntax errors and on values that parse but make no sense (port 0). A failed reload is logged and the old config stays in place.
+
+Polling instead of file events: Checking the file's modification time and size every couple of seconds costs almost nothing, needs no extra packages, and works on every system. Packages like fsnotify react instantly, but editors that save by writing a temporary file and renaming it can confuse them.
+
+New config, not a changed config: Each reload creates a fresh *Config and never modifies the one readers already have. That is what makes sharing it without locks safe, so handlers must treat it as read-only too.
+
+Important: Not every setting can change at runtime. The port is bound once when the server starts. Changing it in the file does nothing until a restart, so say so in your docs (or log a warning when such a field changes).
+
+
+3. Comments in JSON Config Files
+--------------------------------
+JSON has no comments, and encoding/json rejects them. That's a pity for config files, where "why is this 30 and not 10?" is exactly the kind of thing you want to write down next to the value. Editors like VS Code already use JSON with comments (JSONC) for their settings. StripJSONComments lets LoadConfig read the same format:
+
+// StripJSONComments blanks out // and /* */ comments so encoding/json can
+// read JSONC files. Comments become spaces (newlines are kept), so the
+// result has the same length and error offsets still match the original.
+func StripJSONComments(data []byte) []byte {
+    out := make([]byte, len(data))
+    copy(out, data)
+
+    inString := false
+    for i := 0; i < len(out); i++ {
+        c := out[i]
+        switch {
+        case inString:
+            if c == '\\' {
+                i++ // skip the escaped character, it may be a quote
+            } else if c == '"' {
+                inString = false
+            }
+        case c == '"':
+            inString = true
+        case c == '/' && i+1 < len(out) && out[i+1] == '/':
+            for i < len(out) && out[i] != '\n' {
+                out[i] = ' '
+                i++
+            }
+        case c == '/' && i+1 < len(out) && out[i+1] == '*':
+            end := i + 2
+            for end < len(out) && !(out[end] == '*' && end+1 < len(out) && out[end+1] == '/') {
+                end++
+            }
+            end = min(end+2, len(out)) // include the closing */, if there is one
+            blank(out[i:end])
+            i = end - 1
+        }
+    }
+    return out
+}
+
+// blank replaces everything but newlines with spaces, keeping line numbers.
+func blank(b []byte) {
+    for i, c := range b {
+        if c != '\n' && c != '\r' {
+            b[i] = ' '
+        }
+    }
+}
+
+LoadConfig from section 2 now passes the file through it before decoding:
+
+if err := json.Unmarshal(StripJSONComments(data), cfg); err != nil {
+
+So this file loads:
+
+{
+    // 8080 locally, the load balancer expects 80 in production
+    "port": 8080,
+    "log_level": "debug", /* switch back to "info" after the incident */
+    "features": {
+        "new_checkout": true // see https://wiki.example.com/checkout
+    }
+}
+
+What's happening in that code?
+Respecting strings: "https://wiki.example.com" contains //, but it's inside a string, so it stays. A backslash inside a string skips the next character, so an escaped quote (\") doesn't end the string early.
+
+Spaces instead of removing: Comments are overwritten, not cut out. Every value stays at the same byte offset and on the same line, so when json.Unmarshal reports "invalid character at offset 143", offset 143 in the original file is the right place to look.
+
+A copy: The input isn't modified, so the caller's data still holds the file exactly as it was read.
+
+Important: Only comments are supported, not the other JSONC and JSON5 extras. A trailing comma after the last field is still a syntax error. An unterminated /* comment blanks out the rest of the file, which then fails with "unexpected end of JSON input".
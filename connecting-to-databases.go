@@ -280,12 +280,14 @@ MySQL (popular, widely used):
 package main
 
 import (
+    "context"
     "database/sql"
     "encoding/json"
     "fmt"
     "log"
     "net/http"
     "strconv"
+    "time"
 
     _ "github.com/go-sql-driver/mysql"
 )
@@ -305,9 +307,11 @@ func initDB() {
         log.Fatal(err)
     }
     
-    // Test connection
-    if err = db.Ping(); err != nil {
-        log.Fatal(err)
+    // Test connection, but don't wait forever if the host is wrong
+    ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+    defer cancel()
+    if err = db.PingContext(ctx); err != nil {
+        log.Fatal("cannot reach database within 5s: ", err)
     }
     
     // Configure pool
@@ -315,25 +319,80 @@ func initDB() {
     db.SetMaxIdleConns(25)
 }
 
+// The upper bound is maxPageSize, shared with Paginate in http-handler-helpers.go
+const defaultPageSize = 20
+
+// usersPage is one page of users plus what a client needs to build a pager
+type usersPage struct {
+    Users  []User `json:"users"`
+    Total  int    `json:"total"`
+    Limit  int    `json:"limit"`
+    Offset int    `json:"offset"`
+}
+
+// queryInt reads a non-negative integer query parameter, or def if it is absent
+func queryInt(r *http.Request, name string, def int) (int, error) {
+    s := r.URL.Query().Get(name)
+    if s == "" {
+        return def, nil
+    }
+    n, err := strconv.Atoi(s)
+    if err != nil {
+        return 0, fmt.Errorf("%s must be a whole number, got %q", name, s)
+    }
+    if n < 0 {
+        return 0, fmt.Errorf("%s must not be negative, got %d", name, n)
+    }
+    return n, nil
+}
+
 func getUsers(w http.ResponseWriter, r *http.Request) {
-    rows, err := db.Query("SELECT id, name, email FROM users")
+    limit, err := queryInt(r, "limit", defaultPageSize)
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusBadRequest)
+        return
+    }
+    if limit == 0 {
+        limit = defaultPageSize
+    }
+    limit = min(limit, maxPageSize) // a client can't make us send the whole table
+    offset, err := queryInt(r, "offset", 0)
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusBadRequest)
+        return
+    }
+
+    page := usersPage{Users: []User{}, Limit: limit, Offset: offset}
+    if err := db.QueryRowContext(r.Context(), "SELECT COUNT(*) FROM users").Scan(&page.Total); err != nil {
+        http.Error(w, err.Error(), 500)
+        return
+    }
+
+    // ORDER BY makes the pages stable; without it the database may return
+    // rows in a different order each time
+    rows, err := db.QueryContext(r.Context(),
+        "SELECT id, name, email FROM users ORDER BY id LIMIT ? OFFSET ?", limit, offset)
     if err != nil {
         http.Error(w, err.Error(), 500)
         return
     }
     defer rows.Close()
 
-    var users []User
     for rows.Next() {
         var u User
         if err := rows.Scan(&u.ID, &u.Name, &u.Email); err != nil {
             http.Error(w, err.Error(), 500)
             return
         }
-        users = append(users, u)
+        page.Users = append(page.Users, u)
     }
-    
-    json.NewEncoder(w).Encode(users)
+    if err := rows.Err(); err != nil {
+        http.Error(w, err.Error(), 500)
+        return
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(page)
 }
 
 func main() {
@@ -345,6 +404,14 @@ func main() {
     log.Fatal(http.ListenAndServe(":8080", nil))
 }
 
+GET /users?limit=2&offset=40 answers with one page and the total:
+
+{"users":[{"id":41,"name":"Ann","email":"ann@example.com"},{"id":42,"name":"Ben","email":"ben@example.com"}],"total":137,"limit":2,"offset":40}
+
+Without parameters, or with limit=0, a client gets the first 20 users. A larger limit is capped at 100 (maxPageSize), and the response's "limit" says how many were actually asked for, so a client that wanted 500 can see it got pages of 100. offset=-1 or limit=ten are answered with 400 and a message saying which parameter is wrong. Both numbers go into the query as ? arguments, never into the SQL text.
+
+The count and the page are two queries, so a user inserted in between can make total one off. For a pager that's harmless. OFFSET also gets slower the deeper it goes, because the database still reads all the skipped rows; for endless scrolling through a big table, use keyset pagination (Paginate in http-handler-helpers.go) instead.
+
 
 13. ORM vs. Raw SQL
 -------------------
@@ -388,6 +455,66 @@ func TestUserRepository(t *testing.T) {
     // Now run your tests against this db
 }
 
+This works until tests run in parallel (t.Parallel()) or a package has many tests. Every ":memory:" connection gets its own empty database, so as soon as the pool opens a second connection the tables are gone. Sharing one named database instead means tests see each other's rows. NewTestDB gives every test its own named database that all of its connections share:
+
+import (
+    "database/sql"
+    "fmt"
+    "strings"
+    "sync/atomic"
+    "testing"
+)
+
+var testDBCount atomic.Int64
+
+// NewTestDB opens a fresh in-memory SQLite database for one test, runs
+// schema in it, and closes it when the test ends.
+func NewTestDB(t testing.TB, schema string) *sql.DB {
+    t.Helper()
+
+    // Every test gets its own name. Connections that use the same name share
+    // one database, connections with different names never see each other.
+    name := strings.NewReplacer("/", "_", " ", "_").Replace(t.Name())
+    dsn := fmt.Sprintf("file:%s_%d?mode=memory&cache=shared", name, testDBCount.Add(1))
+
+    db, err := sql.Open("sqlite3", dsn)
+    if err != nil {
+        t.Fatalf("open test db: %v", err)
+    }
+    t.Cleanup(func() { db.Close() })
+
+    if _, err := db.Exec(schema); err != nil {
+        t.Fatalf("apply schema: %v", err)
+    }
+    return db
+}
+
+Using it:
+
+const schema = `
+CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT NOT NULL, email TEXT NOT NULL);
+CREATE UNIQUE INDEX users_email ON users (email);
+`
+
+func TestCreateUser(t *testing.T) {
+    t.Parallel()
+    db := NewTestDB(t, schema)
+
+    if _, err := db.Exec("INSERT INTO users (name, email) VALUES (?, ?)", "Ann", "ann@example.com"); err != nil {
+        t.Fatal(err)
+    }
+    // Other parallel tests have their own users table, so this count is always right
+    var n int
+    db.QueryRow("SELECT COUNT(*) FROM users").Scan(&n)
+    if n != 1 {
+        t.Errorf("users = %d, want 1", n)
+    }
+}
+
+file:NAME?mode=memory&cache=shared is SQLite's way of saying "an in-memory database called NAME, shared by every connection that opens it". The test name plus a counter makes NAME unique, even for subtests and when the same test runs twice with -count=2. t.Cleanup closes the pool after the test, and with the last connection the database is gone. The schema string can hold several statements, because the sqlite3 driver runs them all in one Exec.
+
+Important: An in-memory database only lives while at least one connection is open. Don't set SetConnMaxLifetime or SetConnMaxIdleTime on a test database, or the pool may close the last connection between two statements and the next query finds empty tables. SQLite is close to, but not exactly, MySQL or PostgreSQL, so run the important tests against the real database too, for example in CI.
+
 
 15. Common Pitfalls to Avoid
 -----------------------------
@@ -429,4 +556,3 @@ Go's database/sql package provides a powerful, standard interface for working wi
 8. Use context for timeouts and cancellation
 
 With these fundamentals, you can connect Go to virtually any database and build robust, efficient data-driven applications!
-
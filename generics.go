@@ -0,0 +1,488 @@
+Generics in Go
+==============
+
+Since Go 1.18 you can write functions and types that work with many types at once, using type parameters in square brackets:
+
+func Map[T, U any](items []T, fn func(T) U) []U {
+    out := make([]U, 0, len(items))
+    for _, item := range items {
+        out = append(out, fn(item))
+    }
+    return out
+}
+
+lengths := Map([]string{"go", "rust"}, func(s string) int { return len(s) }) // [2 4]
+
+T and U are filled in by the compiler from the arguments, so you rarely have to write them yourself. The constraint after the name says what a type must support: any means anything, comparable means it can be used with == (and therefore as a map key).
+
+This file collects small generic helpers that come up again and again.
+
+
+1. Memoization (Caching Function Results)
+-----------------------------------------
+If a function always returns the same result for the same argument (looking up a country by code, parsing a template, an expensive calculation), you only need to call it once per argument. Memoize wraps such a function and remembers its results.
+
+It also handles a subtle problem. If ten goroutines ask for the same key at the same moment, a naive cache would call the function ten times, because none of them has finished yet. Here only the first caller runs the function. The rest wait for its result. This is often called "single-flight".
+
+import (
+    "fmt"
+    "sync"
+    "time"
+
+    "myapp/clock"
+)
+
+type MemoOptions struct {
+    TTL         time.Duration // 0 means results never expire
+    MaxSize     int           // 0 means no limit; otherwise the oldest entries are evicted
+    CacheErrors bool          // remember failed calls too, instead of retrying them next time
+    Clock       clock.Clock   // nil means the real clock; tests pass a clock.Fake
+}
+
+type memoEntry[V any] struct {
+    val     V
+    err     error
+    done    chan struct{} // closed once val and err are set
+    expires time.Time
+}
+
+type memoOrder[K comparable, V any] struct {
+    key   K
+    entry *memoEntry[V]
+}
+
+// Memoize caches fn's results forever and does not cache errors.
+func Memoize[K comparable, V any](fn func(K) (V, error)) func(K) (V, error) {
+    return MemoizeWith(fn, MemoOptions{})
+}
+
+func MemoizeWith[K comparable, V any](fn func(K) (V, error), opts MemoOptions) func(K) (V, error) {
+    if opts.Clock == nil {
+        opts.Clock = clock.Real()
+    }
+
+    var mu sync.Mutex
+    entries := make(map[K]*memoEntry[V])
+    var order []memoOrder[K, V] // insertion order, only tracked when MaxSize > 0
+
+    evict := func() {
+        for len(entries) > opts.MaxSize && len(order) > 0 {
+            oldest := order[0]
+            order = order[1:]
+            // Skip stale records for entries that were already replaced or removed
+            if entries[oldest.key] == oldest.entry {
+                delete(entries, oldest.key)
+            }
+        }
+        // Stale records pile up when errors aren't cached, so compact now and then
+        if len(order) > 2*opts.MaxSize {
+            live := order[:0]
+            for _, o := range order {
+                if entries[o.key] == o.entry {
+                    live = append(live, o)
+                }
+            }
+            order = live
+        }
+    }
+
+    return func(key K) (V, error) {
+        mu.Lock()
+        if e, ok := entries[key]; ok && (e.expires.IsZero() || opts.Clock.Now().Before(e.expires)) {
+            mu.Unlock()
+            <-e.done // wait if another goroutine is still computing this key
+            return e.val, e.err
+        }
+
+        e := &memoEntry[V]{done: make(chan struct{})}
+        entries[key] = e
+        if opts.MaxSize > 0 {
+            order = append(order, memoOrder[K, V]{key: key, entry: e})
+            evict()
+        }
+        mu.Unlock()
+
+        func() {
+            // However fn ends, even by panicking, the waiters must be released
+            defer close(e.done)
+            defer func() {
+                if p := recover(); p != nil {
+                    var zero V
+                    e.val, e.err = zero, fmt.Errorf("memoize: call for key %v panicked: %v", key, p)
+                }
+                mu.Lock()
+                defer mu.Unlock()
+                if e.err != nil && !opts.CacheErrors {
+                    if entries[key] == e {
+                        delete(entries, key)
+                    }
+                } else if opts.TTL > 0 {
+                    e.expires = opts.Clock.Now().Add(opts.TTL)
+                }
+            }()
+            e.val, e.err = fn(key)
+        }()
+
+        return e.val, e.err
+    }
+}
+
+Using it:
+
+lookupCountry := Memoize(func(code string) (Country, error) {
+    return fetchCountryFromAPI(code) // slow, but the answer never changes
+})
+
+c, err := lookupCountry("KE") // calls the API
+c, err = lookupCountry("KE")  // instant, straight from the cache
+
+// Exchange rates change, so keep them for a minute and never hold more than 500
+rate := MemoizeWith(fetchRate, MemoOptions{TTL: time.Minute, MaxSize: 500})
+
+What's happening in that code?
+The done channel: Each entry starts "in progress". Waiters block on <-e.done, and closing the channel wakes all of them at once when the result is ready.
+
+Holding the lock briefly: The mutex only protects the map. It is released before fn runs, so a slow call for one key never blocks callers asking for other keys.
+
+Eviction: With MaxSize set, the oldest entries are removed first. The order slice might point to entries that were already replaced, so eviction checks that each record is still the live one before deleting it.
+
+Panics: fn runs inside a small function whose deferred calls close done and update the map, so a panicking fn can't leave the other callers waiting forever. The panic is turned into an error for everyone waiting on that call, and, like any error, it is only remembered when CacheErrors is on.
+
+CacheErrors: This is off by default, and for good reason. If the network blips once, caching that error would make the function fail for that key forever (or until the TTL runs out). Turn it on only when an error really is the permanent answer, like "country code does not exist".
+
+Important: Only memoize functions whose result depends on the argument alone. Anything that reads the clock, a database, or global state can return stale data. Use a TTL if that staleness is acceptable.
+
+
+2. A Set Type
+-------------
+Go has no built-in set. The usual trick is a map whose values are empty:
+
+seen := map[int]struct{}{}
+seen[42] = struct{}{}
+if _, ok := seen[42]; ok { ... }
+
+That works, but the intent gets lost in the punctuation. A generic Set wraps the same trick in readable methods:
+
+import (
+    "cmp"
+    "slices"
+)
+
+// Set is an unordered collection of unique values.
+type Set[T comparable] map[T]struct{}
+
+func NewSet[T comparable](items ...T) Set[T] {
+    s := make(Set[T], len(items))
+    s.Add(items...)
+    return s
+}
+
+func (s Set[T]) Add(items ...T) {
+    for _, item := range items {
+        s[item] = struct{}{}
+    }
+}
+
+func (s Set[T]) Remove(item T) {
+    delete(s, item)
+}
+
+func (s Set[T]) Contains(item T) bool {
+    _, ok := s[item]
+    return ok
+}
+
+func (s Set[T]) Len() int {
+    return len(s)
+}
+
+// Union returns a new set with the items of both sets.
+func (s Set[T]) Union(other Set[T]) Set[T] {
+    out := make(Set[T], len(s)+len(other))
+    for item := range s {
+        out[item] = struct{}{}
+    }
+    for item := range other {
+        out[item] = struct{}{}
+    }
+    return out
+}
+
+// Intersect returns a new set with only the items found in both sets.
+func (s Set[T]) Intersect(other Set[T]) Set[T] {
+    small, large := s, other
+    if len(small) > len(large) {
+        small, large = large, small
+    }
+    out := make(Set[T])
+    for item := range small {
+        if large.Contains(item) {
+            out[item] = struct{}{}
+        }
+    }
+    return out
+}
+
+// ToSlice returns the items in no particular order.
+func (s Set[T]) ToSlice() []T {
+    out := make([]T, 0, len(s))
+    for item := range s {
+        out = append(out, item)
+    }
+    return out
+}
+
+// Sorted returns the items in ascending order. It is a function rather than
+// a method because it needs the stricter cmp.Ordered constraint.
+func Sorted[T cmp.Ordered](s Set[T]) []T {
+    out := s.ToSlice()
+    slices.Sort(out)
+    return out
+}
+
+Using it:
+
+// Remove duplicate IDs before building a WHERE id IN (...) query
+ids := NewSet(requestedIDs...)
+
+admins := NewSet("ada", "linus")
+online := NewSet("linus", "grace")
+
+admins.Union(online)     // {ada, linus, grace}
+admins.Intersect(online) // {linus}
+Sorted(admins)           // [ada linus]
+
+What's happening in that code?
+struct{} values: An empty struct takes zero bytes, so the map only stores the keys. That is why struct{} is used instead of bool.
+
+A map type with methods: Set[T] is a map, so it works with len(), range and make directly. Because maps are references, methods like Add change the set even though they don't use a pointer receiver.
+
+Union and Intersect return new sets: Neither input is changed, so it is safe to reuse admins afterwards. Intersect loops over the smaller set to do less work.
+
+Sorted is separate: ToSlice works for any comparable type, but sorting needs < which only cmp.Ordered types (numbers and strings) have. Methods can't add extra constraints, so Sorted is a plain function.
+
+Important: Like a normal map, a Set is not safe for concurrent use. Protect it with a sync.Mutex if several goroutines add to it.
+
+
+3. An LRU Cache
+---------------
+Memoize from section 1 forgets entries after a TTL, or drops the oldest once MaxSize is reached. For query results that stay valid for a long time, the better question is which entries are still being used. An LRU (least recently used) cache keeps a fixed number of entries and, when it is full, throws out the one nobody has asked for the longest.
+
+Both Get and Add have to be O(1): find the entry (a map), and move it to the front or remove the last one (a doubly-linked list). Instead of allocating a node per entry like container/list does, the nodes live in one slice and link to each other by index. The slice never grows beyond the capacity, and an evicted entry's slot is reused for the new one.
+
+import "sync"
+
+// LRU is a cache of at most capacity entries. When it is full, adding a new
+// key evicts the entry that was used least recently. It is safe for
+// concurrent use.
+type LRU[K comparable, V any] struct {
+    // OnEvict, if set, is called with every entry pushed out to make room.
+    // It runs without the lock held, so it may use the cache.
+    OnEvict func(key K, value V)
+
+    mu       sync.Mutex
+    capacity int
+    index    map[K]int        // key -> position in entries
+    entries  []lruEntry[K, V] // the list nodes, linked by position
+    head     int              // most recently used, -1 when empty
+    tail     int              // least recently used, -1 when empty
+}
+
+type lruEntry[K comparable, V any] struct {
+    key        K
+    value      V
+    prev, next int // -1 at either end of the list
+}
+
+// NewLRU returns an empty cache. A capacity below 1 is treated as 1.
+func NewLRU[K comparable, V any](capacity int) *LRU[K, V] {
+    capacity = max(capacity, 1)
+    return &LRU[K, V]{
+        capacity: capacity,
+        index:    make(map[K]int, capacity),
+        entries:  make([]lruEntry[K, V], 0, capacity),
+        head:     -1,
+        tail:     -1,
+    }
+}
+
+// Get returns the value for key and marks it as recently used.
+func (c *LRU[K, V]) Get(key K) (V, bool) {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    i, ok := c.index[key]
+    if !ok {
+        var zero V
+        return zero, false
+    }
+    c.moveToFront(i)
+    return c.entries[i].value, true
+}
+
+// Add stores value under key, evicting the least recently used entry if
+// the cache is full.
+func (c *LRU[K, V]) Add(key K, value V) {
+    c.mu.Lock()
+    if i, ok := c.index[key]; ok {
+        c.entries[i].value = value
+        c.moveToFront(i)
+        c.mu.Unlock()
+        return
+    }
+
+    var evicted *lruEntry[K, V]
+    var i int
+    if len(c.entries) < c.capacity {
+        c.entries = append(c.entries, lruEntry[K, V]{})
+        i = len(c.entries) - 1
+    } else {
+        // Full: reuse the tail's slot for the new entry
+        i = c.tail
+        old := c.entries[i]
+        evicted = &old
+        delete(c.index, old.key)
+        c.unlink(i)
+    }
+    c.entries[i] = lruEntry[K, V]{key: key, value: value, prev: -1, next: -1}
+    c.index[key] = i
+    c.pushFront(i)
+    onEvict := c.OnEvict
+    c.mu.Unlock()
+
+    if evicted != nil && onEvict != nil {
+        onEvict(evicted.key, evicted.value)
+    }
+}
+
+func (c *LRU[K, V]) Len() int {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    return len(c.index)
+}
+
+func (c *LRU[K, V]) moveToFront(i int) {
+    if c.head == i {
+        return
+    }
+    c.unlink(i)
+    c.pushFront(i)
+}
+
+func (c *LRU[K, V]) unlink(i int) {
+    e := &c.entries[i]
+    if e.prev >= 0 {
+        c.entries[e.prev].next = e.next
+    } else {
+        c.head = e.next
+    }
+    if e.next >= 0 {
+        c.entries[e.next].prev = e.prev
+    } else {
+        c.tail = e.prev
+    }
+    e.prev, e.next = -1, -1
+}
+
+func (c *LRU[K, V]) pushFront(i int) {
+    e := &c.entries[i]
+    e.prev, e.next = -1, c.head
+    if c.head >= 0 {
+        c.entries[c.head].prev = i
+    }
+    c.head = i
+    if c.tail < 0 {
+        c.tail = i
+    }
+}
+
+Using it:
+
+products := NewLRU[int, Product](1000)
+products.OnEvict = func(id int, p Product) {
+    log.Printf("evicted product %d", id)
+}
+
+func getProduct(ctx context.Context, id int) (Product, error) {
+    if p, ok := products.Get(id); ok {
+        return p, nil
+    }
+    p, err := loadProduct(ctx, id) // SELECT ... WHERE id = ?
+    if err != nil {
+        return Product{}, err
+    }
+    products.Add(id, p)
+    return p, nil
+}
+
+What's happening in that code?
+The list by index: prev and next are positions in entries, with -1 for "none". head is the most recently used entry and tail the least recently used, so eviction takes the tail and every Get moves its entry to the head.
+
+Reusing the slot: When the cache is full, the new entry overwrites the tail's slot after it is unlinked, so after warm-up Add allocates nothing (apart from the map, which reuses its space too).
+
+OnEvict outside the lock: The callback runs after the mutex is released. A callback that calls back into the cache (or just takes a while, like writing to disk) doesn't deadlock or block the other goroutines.
+
+Important: Two goroutines that miss the same key at the same time both run loadProduct. That's fine for cheap queries. For expensive ones, put Memoize in front, which lets concurrent callers share one call. An LRU counts entries, not bytes, so pick the capacity with the size of one value in mind.
+
+
+4. Trying Sources in Order (FirstSuccess)
+-----------------------------------------
+Some data has more than one place it can come from. A product lives in the primary database, but a replica or a cache can answer too when the primary is down. The code for "try this, and if it fails try that" is always the same, whatever the type of the result, so it makes a good generic helper.
+
+import (
+    "context"
+    "errors"
+    "fmt"
+)
+
+// FirstSuccess runs attempts one after another and returns the first result
+// without an error. If every attempt fails, the error joins all of their
+// errors. It stops early, with ctx's error, once ctx is done.
+func FirstSuccess[T any](ctx context.Context, attempts ...func(context.Context) (T, error)) (T, error) {
+    var zero T
+    var errs []error
+    for i, attempt := range attempts {
+        if err := ctx.Err(); err != nil {
+            return zero, errors.Join(append(errs, err)...)
+        }
+        v, err := attempt(ctx)
+        if err == nil {
+            return v, nil
+        }
+        errs = append(errs, fmt.Errorf("attempt %d: %w", i+1, err))
+    }
+    if len(errs) == 0 {
+        return zero, errors.New("FirstSuccess: no attempts given")
+    }
+    return zero, errors.Join(errs...)
+}
+
+Using it:
+
+product, err := FirstSuccess(ctx,
+    func(ctx context.Context) (Product, error) { return loadProduct(ctx, primary, id) },
+    func(ctx context.Context) (Product, error) { return loadProduct(ctx, replica, id) },
+    func(ctx context.Context) (Product, error) {
+        if p, ok := products.Get(id); ok { // the LRU from section 3
+            return p, nil
+        }
+        return Product{}, ErrNotFound
+    },
+)
+if err != nil {
+    log.Printf("no source had product %d: %v", id, err)
+}
+
+When all three fail, err prints one line per attempt:
+
+attempt 1: dial tcp 10.0.0.5:5432: connection refused
+attempt 2: context deadline exceeded
+attempt 3: not found
+
+What's happening in that code?
+Type inference: T is taken from the attempts, so the call never has to say FirstSuccess[Product]. All attempts must return the same type, which the compiler checks.
+
+Joined errors: errors.Join keeps every failure, not just the last one, and errors.Is and errors.As look through all of them. errors.Is(err, ErrNotFound) is true above even though two other errors came first.
+
+Checking ctx between attempts: If the request was cancelled while the primary was timing out, there's no point asking the replica too. The context's error is added to the others, so errors.Is(err, context.Canceled) works for the caller.
+
+Important: The attempts run one at a time, in order, so a slow primary delays the fallbacks. Give each attempt its own deadline (context.WithTimeout inside the function) when the first source can hang. And only fall back for errors where the next source might actually help: a "not found" from the primary usually means the replica won't have the row either.
@@ -23,35 +23,293 @@ package main
 
 import (
     "fmt"
+    "io"
+    "net/http"
+    "sync"
     "time"
 )
 
-func download(site string, c chan string) {
-    fmt.Println("Starting download from:", site)
-    time.Sleep(2 * time.Second) // Simulate a slow download
-    c <- site + " is done!"      // Send a message INTO the channel
+// DownloadResult is what each download sends back through the channel
+type DownloadResult struct {
+    URL      string
+    Bytes    int64         // how much of the body we read
+    Duration time.Duration // how long the whole download took
+    Err      error         // nil if everything went fine
+}
+
+var client = &http.Client{Timeout: 10 * time.Second} // never wait forever
+
+func downloadSite(url string, c chan DownloadResult) {
+    fmt.Println("Starting download from:", url)
+    start := time.Now()
+
+    resp, err := client.Get(url)
+    if err != nil { // DNS failure, refused connection, timeout...
+        c <- DownloadResult{URL: url, Duration: time.Since(start), Err: err}
+        return
+    }
+    defer resp.Body.Close()
+
+    // Read the whole body, counting the bytes instead of keeping them
+    n, err := io.Copy(io.Discard, resp.Body)
+    if err == nil && (resp.StatusCode < 200 || resp.StatusCode > 299) {
+        err = fmt.Errorf("bad status: %s", resp.Status)
+    }
+    c <- DownloadResult{URL: url, Bytes: n, Duration: time.Since(start), Err: err} // Send the result INTO the channel
+}
+
+// Collect receives results from c until c is closed or n of them have
+// arrived, whichever comes first.
+func Collect(c chan DownloadResult, n int) []DownloadResult {
+    results := make([]DownloadResult, 0, n)
+    for r := range c {
+        results = append(results, r)
+        if len(results) == n {
+            break
+        }
+    }
+    return results
 }
 
 func main() {
-    // 1. Create a channel that transports strings
-    c := make(chan string)
-
-    // 2. Launch three tasks concurrently
-    go download("Google.com", c)
-    go download("Amazon.com", c)
-    go download("Github.com", c)
-
-    // 3. Receive the results as they come in
-    // This part "blocks" (waits) until data arrives
-    fmt.Println(<-c) 
-    fmt.Println(<-c)
-    fmt.Println(<-c)
-    
+    sites := []string{
+        "https://google.com",
+        "https://amazon.com",
+        "https://github.com",
+    }
+
+    // 1. Create a channel that transports Results
+    c := make(chan DownloadResult)
+
+    // 2. Launch one task per site, counting them in a WaitGroup
+    var wg sync.WaitGroup
+    for _, site := range sites {
+        wg.Add(1)
+        go func() {
+            defer wg.Done()
+            downloadSite(site, c)
+        }()
+    }
+
+    // 3. Close the channel once every task is done. This has to run in its
+    // own goroutine: wg.Wait() only returns once every DownloadResult has been
+    // received, and receiving them is what main does next
+    go func() {
+        wg.Wait()
+        close(c)
+    }()
+
+    // 4. Receive the results as they come in
+    // This part "blocks" (waits) until data arrives, and ends when c is closed
+    for _, r := range Collect(c, len(sites)) {
+        switch {
+        case r.Err != nil:
+            fmt.Printf("FAILED %s after %v: %v\n", r.URL, r.Duration, r.Err)
+        default:
+            fmt.Printf("%s is done! %d bytes in %v\n", r.URL, r.Bytes, r.Duration)
+        }
+    }
+
     fmt.Println("All downloads finished!")
 }
-4. Why this mattersIn the program above, if we didn't use go, it would take 6 seconds (2 seconds per site). 
-Because we used Goroutines, it only takes 2 seconds total because all three are happening at the same time.
+4. Why this mattersIn the program above, if we didn't use go, the total time would be all three downloads added up, one after another. 
+Because we used Goroutines, it only takes as long as the slowest site, because all three are happening at the same time.
+Each DownloadResult also carries its own Err, so one site failing (a typo in the URL, a 404, the connection dropping halfway) is reported for that site only, and the other two still finish normally.
+Note that a 404 or 500 is not an error for http.Get: the server did answer. That's why downloadSite checks resp.StatusCode itself.
+Adding a fourth site is one more line in sites and nothing else. main never counts sends itself: the WaitGroup knows how many downloads are still running, and the goroutine running wg.Wait() closes c right after the last one has sent its DownloadResult. A closed channel ends a range loop, so Collect (and any for r := range c) stops exactly when there is nothing more to come.
+The closer goroutine is a common stumbling block. Calling wg.Wait(); close(c) directly in main, before receiving, deadlocks: the downloads can't finish because nobody receives their DownloadResult, and nobody receives because main is still waiting for the downloads. Closing c from inside downloadSite instead would close it once per site, and the second close panics. One goroutine that waits and then closes, exactly once, is the pattern.
+Collect also stops after n results, so it works just as well with a channel that is never closed, as long as n is right.
+
+
+5. Many Links: A Fixed Number of Workers
+One goroutine per site is perfect for three URLs. Feed the same code 10,000 links and it opens 10,000 connections at once: the program runs out of file descriptors, and the sites (or your own network) start refusing you.
+The fix is a worker pool. A few goroutines (the workers) each take a URL from a jobs channel, download it, and go back for the next one. However many URLs there are, only that many downloads run at the same time.
+
+import (
+    "runtime"
+    "sync"
+)
+
+// DownloadAll downloads every URL using at most workers goroutines and
+// returns one DownloadResult per URL, in the order they finished.
+// workers <= 0 means one worker per CPU.
+func DownloadAll(urls []string, workers int) []DownloadResult {
+    if workers <= 0 {
+        workers = runtime.NumCPU()
+    }
+    if workers > len(urls) {
+        workers = len(urls) // no point starting workers that never get a job
+    }
+
+    jobs := make(chan string)
+    results := make(chan DownloadResult)
+
+    // 1. Start the workers. Each one keeps taking URLs until jobs is closed
+    var wg sync.WaitGroup
+    for i := 0; i < workers; i++ {
+        wg.Add(1)
+        go func() {
+            defer wg.Done()
+            for url := range jobs {
+                downloadSite(url, results)
+            }
+        }()
+    }
+
+    // 2. Hand out the URLs, then close jobs so the workers' loops end
+    go func() {
+        for _, url := range urls {
+            jobs <- url
+        }
+        close(jobs)
+    }()
+
+    // 3. Once every worker is done, nothing sends on results anymore
+    go func() {
+        wg.Wait()
+        close(results)
+    }()
+
+    // 4. Collect until results is closed
+    return Collect(results, len(urls))
+}
+
+Using it:
+
+results := DownloadAll(urls, 20) // never more than 20 downloads at a time
+for _, r := range results {
+    if r.Err != nil {
+        fmt.Printf("FAILED %s: %v\n", r.URL, r.Err)
+    }
+}
+
+What's happening in that code?
+Every URL exactly once: jobs is a single channel, and a value sent on a channel is received by exactly one goroutine. So no URL is downloaded twice, and because downloadSite always sends one DownloadResult (success or failure), every URL appears exactly once in the output.
+Closing in the right place: Only the sender closes a channel. The goroutine that hands out URLs closes jobs; the goroutine that waits for all workers closes results. Closing results any earlier would make a worker that's still busy panic with "send on closed channel".
+Why the feeding happens in its own goroutine: jobs and results are unbuffered. If DownloadAll sent all URLs first and only then started reading results, the workers would block sending their first DownloadResult and never come back for another job: a deadlock. Feeding and collecting have to happen at the same time.
+Order: Results come back in the order the downloads finish, not the order of urls. Use r.URL to match them up.
+
+6. Stopping a Crawl: Cancellation and a Time Limit
+DownloadAll has no off switch. The client's 10 second Timeout limits each download, but with 10,000 URLs and a few slow servers the whole run can still take hours, and once it has started nothing can stop it. A context.Context is Go's standard way to say "stop now" (Ctrl+C, a cancelled request) or "stop at 12:00:30" (a deadline), so the downloader takes one.
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "io"
+    "net/http"
+    "runtime"
+    "sync"
+    "time"
+)
+
+// downloadSiteContext is downloadSite with a context: cancelling ctx aborts the
+// request, even halfway through the body.
+func downloadSiteContext(ctx context.Context, url string, c chan DownloadResult) {
+    start := time.Now()
+    n, err := fetch(ctx, url)
+    c <- DownloadResult{URL: url, Bytes: n, Duration: time.Since(start), Err: err}
+}
+
+// fetch reads url's body to the end and returns how many bytes it had.
+func fetch(ctx context.Context, url string) (int64, error) {
+    req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+    if err != nil {
+        return 0, err
+    }
+    resp, err := client.Do(req)
+    if err != nil {
+        return 0, contextErr(ctx, err)
+    }
+    defer resp.Body.Close()
+
+    n, err := io.Copy(io.Discard, resp.Body)
+    if err != nil {
+        return n, contextErr(ctx, err)
+    }
+    if resp.StatusCode < 200 || resp.StatusCode > 299 {
+        return n, fmt.Errorf("bad status: %s", resp.Status)
+    }
+    return n, nil
+}
+
+// contextErr makes sure an error caused by ctx ending wraps ctx.Err(), so
+// errors.Is(err, context.DeadlineExceeded) works for every result.
+func contextErr(ctx context.Context, err error) error {
+    if ctxErr := ctx.Err(); ctxErr != nil && !errors.Is(err, ctxErr) {
+        return fmt.Errorf("%w: %v", ctxErr, err)
+    }
+    return err
+}
+
+// DownloadAllContext is DownloadAll with a context. Once ctx is done,
+// downloads in progress are aborted and URLs not yet started are skipped;
+// both come back with an error wrapping ctx.Err().
+func DownloadAllContext(ctx context.Context, urls []string, workers int) []DownloadResult {
+    if workers <= 0 {
+        workers = runtime.NumCPU()
+    }
+    if workers > len(urls) {
+        workers = len(urls)
+    }
+
+    jobs := make(chan string)
+    results := make(chan DownloadResult)
+
+    var wg sync.WaitGroup
+    for i := 0; i < workers; i++ {
+        wg.Add(1)
+        go func() {
+            defer wg.Done()
+            for url := range jobs {
+                if err := ctx.Err(); err != nil {
+                    // Too late to start, but the URL still gets its DownloadResult
+                    results <- DownloadResult{URL: url, Err: fmt.Errorf("not started: %w", err)}
+                    continue
+                }
+                downloadSiteContext(ctx, url, results)
+            }
+        }()
+    }
+
+    go func() {
+        for _, url := range urls {
+            jobs <- url
+        }
+        close(jobs)
+    }()
+
+    go func() {
+        wg.Wait()
+        close(results)
+    }()
+
+    return Collect(results, len(urls))
+}
+
+DownloadAll from section 5 stays as it is. It does the same as DownloadAllContext(context.Background(), urls, workers), which never gets cancelled.
+
+Using it to cap a crawl at 30 seconds in total:
+
+ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+defer cancel()
+
+results := DownloadAllContext(ctx, urls, 20)
+for _, r := range results {
+    if errors.Is(r.Err, context.DeadlineExceeded) {
+        fmt.Printf("%s: out of time\n", r.URL)
+    }
+}
+
+And to stop on Ctrl+C instead, use signal.NotifyContext(context.Background(), os.Interrupt) in place of WithTimeout.
+
+What's happening in that code?
+NewRequestWithContext: client.Get has no way to take a context. A request built with one is watched by the http.Client: when ctx ends, the connection is closed, and whichever call is waiting (Do, or io.Copy reading the body) returns an error straight away.
+contextErr: The error the http package returns then is usually a *url.Error that already wraps context.DeadlineExceeded. A body read that was cut off can also just say "use of closed network connection". contextErr adds ctx.Err() in front whenever ctx is the reason, so the caller only has to check errors.Is.
+Skipped URLs still get a DownloadResult: The feeder keeps handing out every URL even after the deadline. A worker that gets one checks ctx first and answers with an error immediately, which takes microseconds. That keeps "every URL exactly once" from section 5 true, and it means the same three channels and the same closing order still shut everything down: jobs is closed after the last URL, results after the last worker.
+No leaks: DownloadAllContext returns once it has one DownloadResult per URL. By then jobs has been drained and closed, so every worker's loop ends right after its last send, and the closer goroutine closes results and exits after them. No goroutine is left behind, cancelled or not.
 
+Important: Always call cancel (the defer above), even if the timeout never fires; otherwise the timer behind WithTimeout lives on until it runs out. The client's 10 second Timeout still applies to every single download, so the shorter of the two limits wins.
 
 Key Channel Syntax
 Syntax,Action
@@ -0,0 +1,306 @@
+Calling Other APIs (HTTP Client)
+================================
+
+build-a-web-server-using-go.go lists http.Get as the way to fetch data from another server. That's fine for a quick script, but a service that calls other APIs all day needs more. http.Get has no timeout, so a server that never answers blocks the caller forever. There's no way to cancel it either, and every call repeats the same JSON encoding, header, and status code handling.
+
+This file builds a small Client on top of net/http that does those parts once.
+
+
+1. A Small JSON Client
+----------------------
+import (
+    "bytes"
+    "context"
+    "encoding/json"
+    "fmt"
+    "io"
+    "net/http"
+    "strings"
+    "time"
+)
+
+// Client calls a JSON API at baseURL. Every method takes a context, so a
+// caller can give up on a request at any time.
+type Client struct {
+    baseURL string
+    http    *http.Client
+    header  http.Header
+}
+
+type ClientOption func(*Client)
+
+// WithHTTPClient replaces the default http.Client (10s timeout).
+func WithHTTPClient(hc *http.Client) ClientOption {
+    return func(c *Client) { c.http = hc }
+}
+
+// WithHeader adds a header to every request, e.g. an API key.
+func WithHeader(key, value string) ClientOption {
+    return func(c *Client) { c.header.Add(key, value) }
+}
+
+func NewClient(baseURL string, opts ...ClientOption) *Client {
+    c := &Client{
+        baseURL: strings.TrimSuffix(baseURL, "/"),
+        http:    &http.Client{Timeout: 10 * time.Second},
+        header:  make(http.Header),
+    }
+    for _, opt := range opts {
+        opt(c)
+    }
+    return c
+}
+
+// StatusError is returned for responses with a 4xx or 5xx status.
+type StatusError struct {
+    Method     string
+    URL        string
+    Code       int
+    Body       string        // the start of the response body, for the error message
+    RetryAfter time.Duration // from the Retry-After header, 0 if there was none
+}
+
+func (e *StatusError) Error() string {
+    return fmt.Sprintf("%s %s: %d %s: %s", e.Method, e.URL, e.Code, http.StatusText(e.Code), e.Body)
+}
+
+func (c *Client) Get(ctx context.Context, path string, out any) error {
+    return c.Do(ctx, http.MethodGet, path, nil, out)
+}
+
+func (c *Client) Post(ctx context.Context, path string, body, out any) error {
+    return c.Do(ctx, http.MethodPost, path, body, out)
+}
+
+func (c *Client) Put(ctx context.Context, path string, body, out any) error {
+    return c.Do(ctx, http.MethodPut, path, body, out)
+}
+
+func (c *Client) Delete(ctx context.Context, path string) error {
+    return c.Do(ctx, http.MethodDelete, path, nil, nil)
+}
+
+// Do sends body as JSON (unless it is nil) and decodes the response into out
+// (unless it is nil).
+func (c *Client) Do(ctx context.Context, method, path string, body, out any) error {
+    var reqBody io.Reader
+    if body != nil {
+        b, err := json.Marshal(body)
+        if err != nil {
+            return fmt.Errorf("encode request: %w", err)
+        }
+        reqBody = bytes.NewReader(b)
+    }
+
+    req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, reqBody)
+    if err != nil {
+        return err
+    }
+    for key, values := range c.header {
+        req.Header[key] = values
+    }
+    req.Header.Set("Accept", "application/json")
+    if body != nil {
+        req.Header.Set("Content-Type", "application/json")
+    }
+
+    resp, err := c.http.Do(req)
+    if err != nil {
+        return err
+    }
+    defer resp.Body.Close()
+
+    if resp.StatusCode >= 400 {
+        snippet, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+        retryAfter, _ := ParseRetryAfter(resp.Header.Get("Retry-After"), time.Now())
+        return &StatusError{Method: method, URL: req.URL.String(), Code: resp.StatusCode,
+            Body: strings.TrimSpace(string(snippet)), RetryAfter: retryAfter}
+    }
+    if out == nil {
+        io.Copy(io.Discard, resp.Body) // lets the connection be reused
+        return nil
+    }
+    if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+        return fmt.Errorf("decode response: %w", err)
+    }
+    return nil
+}
+
+// Close drops the idle connections kept for reuse.
+func (c *Client) Close() {
+    c.http.CloseIdleConnections()
+}
+
+Using it:
+
+api := NewClient("https://api.example.com",
+    WithHeader("X-Api-Key", MustEnv[string]("API_KEY")), // configuration.go
+)
+defer api.Close()
+
+var user User
+if err := api.Get(ctx, "/users/42", &user); err != nil {
+    var statusErr *StatusError
+    if errors.As(err, &statusErr) && statusErr.Code == http.StatusNotFound {
+        // no such user
+    }
+    return err
+}
+
+created := User{Name: "Ann", Email: "ann@example.com"}
+if err := api.Post(ctx, "/users", created, &created); err != nil {
+    return err
+}
+
+What's happening in that code?
+NewRequestWithContext: The request carries ctx. If ctx is cancelled or its deadline passes, the transport aborts the request, even halfway through a slow response.
+
+Two timeouts: The http.Client's Timeout puts a limit on every request, including reading the body. ctx lets the caller set a shorter one for a single call, for example the remaining time from WithBudget in http-handler-helpers.go.
+
+StatusError: A 404 or 500 is a successful HTTP exchange, so http.Client doesn't return an error for it. Do turns it into one and keeps the first kilobyte of the body, because that is usually where the server explains what went wrong.
+
+Draining the body: A connection can only be reused once its response body has been read to the end and closed. When there is nothing to decode, io.Copy to io.Discard does that.
+
+Important: Create one Client per API and share it. Each http.Client keeps a pool of open connections, so making a new one per request throws away the pool and opens a fresh TCP (and TLS) connection every time. A Client is safe to use from many goroutines at once.
+
+
+2. Cancelling a Request Halfway
+-------------------------------
+Every method of Client takes a context, and that's the whole cancellation API: cancel the context, and the call returns. It's worth knowing what happens underneath, because a request can be cancelled at two different moments.
+
+Waiting for the response: c.http.Do is still waiting for the status line and headers. The transport notices that ctx is done, closes the connection and returns an error that wraps context.Canceled (or context.DeadlineExceeded).
+
+Reading the body: Do has already returned, and json.NewDecoder is reading resp.Body. The body is tied to the same ctx, so the next Read fails with context.Canceled, the decoder gives up, and the deferred resp.Body.Close() releases the connection. The error comes back as "decode response: context canceled", and because Do wraps with %w, errors.Is still finds context.Canceled in it.
+
+In both cases the call returns right away, not when the server finally finishes. A test makes sure it stays that way:
+
+import (
+    "context"
+    "errors"
+    "net/http"
+    "net/http/httptest"
+    "testing"
+    "time"
+)
+
+func TestClientCancel(t *testing.T) {
+    // A server that starts answering, then takes its time with the rest
+    srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        w.Header().Set("Content-Type", "application/json")
+        w.Write([]byte(`{"items":[1,2,`))
+        w.(http.Flusher).Flush()
+        select {
+        case <-time.After(10 * time.Second):
+        case <-r.Context().Done(): // the client hung up
+        }
+    }))
+    defer srv.Close()
+    client := NewClient(srv.URL)
+
+    ctx, cancel := context.WithCancel(context.Background())
+    time.AfterFunc(100*time.Millisecond, cancel) // cancel halfway through the body
+
+    start := time.Now()
+    var out struct{ Items []int }
+    err := client.Get(ctx, "/slow", &out)
+
+    if !errors.Is(err, context.Canceled) {
+        t.Fatalf("got error %v, want context.Canceled", err)
+    }
+    if elapsed := time.Since(start); elapsed > time.Second {
+        t.Fatalf("Get returned after %v, want right after the cancel", elapsed)
+    }
+}
+
+What's happening in that code?
+A half-finished response: The handler writes the start of a JSON document and flushes it, so the client gets its 200 and starts decoding. That's the harder of the two cases: the cancel has to reach a body that is already being read.
+
+time.AfterFunc: Cancels the context from another goroutine while Get is blocked, the same way a client hanging up on your own handler would cancel r.Context().
+
+Asserting on time: errors.Is alone isn't enough. A Client that ignored ctx would still fail eventually, after the server's 10 seconds, and might even return an error that mentions the context. Checking that Get returned within a second is what proves the request was really aborted.
+
+The server side: The handler also watches r.Context(), so it stops as soon as the client's connection closes and srv.Close() doesn't have to wait out the 10 seconds.
+
+Important: Cancelling only works if the context reaches the call. Pass r.Context() (or something derived from it) from your handlers, never context.Background(), or a user who closes the page leaves your service waiting on the other API for nothing. After an error, resp.Body must still be closed; Do does that in a defer on every path, so callers never see the body at all.
+
+
+3. Respecting Retry-After
+-------------------------
+When a server answers 429 Too Many Requests or 503 Service Unavailable, it often says how long to back off in a Retry-After header. The value comes in two forms: a number of seconds ("120") or an HTTP date ("Wed, 21 Oct 2015 07:28:00 GMT"). ParseRetryAfter handles both:
+
+import (
+    "math"
+    "net/http"
+    "strconv"
+    "strings"
+    "time"
+)
+
+// ParseRetryAfter reads a Retry-After header, which is either a number of
+// seconds ("120") or an HTTP date ("Wed, 21 Oct 2015 07:28:00 GMT"), and
+// returns how long to wait from now. A date in the past means no wait. ok
+// is false for an empty or malformed header, so the caller can use its own
+// backoff instead.
+func ParseRetryAfter(header string, now time.Time) (time.Duration, bool) {
+    header = strings.TrimSpace(header)
+    if header == "" {
+        return 0, false
+    }
+
+    if secs, err := strconv.ParseInt(header, 10, 64); err == nil {
+        if secs < 0 || secs > math.MaxInt64/int64(time.Second) {
+            return 0, false
+        }
+        return time.Duration(secs) * time.Second, true
+    }
+
+    // http.ParseTime accepts all three date formats HTTP allows
+    at, err := http.ParseTime(header)
+    if err != nil {
+        return 0, false
+    }
+    return max(at.Sub(now), 0), true
+}
+
+Do reads the header for every failed response and puts the result in the RetryAfter field of the StatusError from section 1, next to the status code. It stays 0 if there was no usable header.
+
+The Client itself doesn't retry, and there's no circuit breaker in this file yet. A caller that wants to retry writes the loop and lets the server pick the wait when it gave one:
+
+func getWithRetry(ctx context.Context, c *Client, path string, out any) error {
+    delay := time.Second
+    for attempt := 1; ; attempt++ {
+        err := c.Get(ctx, path, out)
+
+        var se *StatusError
+        if attempt == 3 || !errors.As(err, &se) ||
+            (se.Code != http.StatusTooManyRequests && se.Code != http.StatusServiceUnavailable) {
+            return err
+        }
+
+        wait := delay
+        if se.RetryAfter > 0 {
+            wait = min(se.RetryAfter, 30*time.Second)
+        }
+        select {
+        case <-time.After(wait):
+        case <-ctx.Done():
+            return ctx.Err()
+        }
+        delay *= 2
+    }
+}
+
+What's happening in that code?
+Two formats: strconv.ParseInt is tried first because the seconds form is by far the more common one. If that fails, http.ParseTime tries the three date formats HTTP allows (RFC 1123, RFC 850 and asctime), so ParseRetryAfter doesn't need to list them itself.
+
+now as a parameter: The date form is turned into a duration relative to now. Passing now in, instead of calling time.Now() inside, makes the function easy to test with a fixed time. Do just passes time.Now().
+
+A date in the past: The server's clock may be a little ahead of or behind yours, so a date that has already passed means "retry now", not an error. max clamps it to 0 and ok stays true.
+
+The ok result: An empty header, "soon", "-5" or a number so large it overflows time.Duration all come back as 0, false. The caller then falls back to its own backoff instead of waiting 0s or forever.
+
+Capping the wait: getWithRetry never waits more than 30 seconds, whatever the server says. A misconfigured server that sends Retry-After: 86400 shouldn't hold a goroutine for a day. The select on ctx.Done() means a cancelled request stops waiting right away, as in section 2.
+
+Only 429 and 503: Those are the statuses where Retry-After means "try again later". A 400 or 404 will fail the same way every time, so it's returned at once. SubmitTask in worker-pools.go retries with a doubling delay too, but it can't know how long the other side wants; here the server says so.
+
+Important: Only retry requests that are safe to repeat. A GET is, but a POST that timed out may already have run on the server, and sending it again can create a second order or a second payment. For those, send an Idempotency-Key header (see Idempotency in http-middleware.go) so the server can recognise the repeat.
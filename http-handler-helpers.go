@@ -0,0 +1,760 @@
+HTTP Handler Helpers
+====================
+
+Every handler repeats the same small chores: checking headers, validating input, writing errors. This file collects helpers for those chores so each handler can stay focused on its actual job. They all work with plain http.ResponseWriter and *http.Request, so they fit any handler, whether it is registered with http.HandleFunc or with the Router from routing.go.
+
+
+1. Conditional Requests (If-Modified-Since)
+-------------------------------------------
+Some data rarely changes: a product catalog, a list of countries, a user's avatar. Sending it again on every request wastes bandwidth. HTTP has a built-in solution:
+
+1. The server sends a Last-Modified header with the response.
+2. The browser stores the response and, next time, sends the date back in If-Modified-Since.
+3. If nothing changed, the server answers 304 Not Modified with an empty body and the browser uses its copy.
+
+import (
+    "net/http"
+    "time"
+)
+
+// SetLastModified sets the Last-Modified header. A zero time sets nothing.
+func SetLastModified(w http.ResponseWriter, t time.Time) {
+    if t.IsZero() {
+        return
+    }
+    w.Header().Set("Last-Modified", t.UTC().Format(http.TimeFormat))
+}
+
+// NotModified sets Last-Modified and, if the client's copy is still current,
+// writes 304 Not Modified and returns true. The handler should then return.
+func NotModified(w http.ResponseWriter, r *http.Request, lastMod time.Time) bool {
+    SetLastModified(w, lastMod)
+
+    if r.Method != http.MethodGet && r.Method != http.MethodHead {
+        return false
+    }
+    // An ETag check (If-None-Match) takes priority over dates when both are sent
+    if r.Header.Get("If-None-Match") != "" || lastMod.IsZero() {
+        return false
+    }
+    since, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+    if err != nil {
+        return false // missing or malformed header: send the full response
+    }
+
+    // HTTP dates only have whole seconds, so compare at that precision
+    if lastMod.Truncate(time.Second).After(since) {
+        return false
+    }
+    w.WriteHeader(http.StatusNotModified)
+    return true
+}
+
+Using it in a handler:
+
+func getCatalog(w http.ResponseWriter, r *http.Request) {
+    updatedAt, err := catalogLastUpdated() // e.g. SELECT MAX(updated_at) FROM products
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusInternalServerError)
+        return
+    }
+    if NotModified(w, r, updatedAt) {
+        return // 304 already sent, the client uses its cached copy
+    }
+
+    products, err := loadCatalog()
+    ...
+    json.NewEncoder(w).Encode(products)
+}
+
+What's happening in that code?
+http.TimeFormat and http.ParseTime: HTTP dates look like "Wed, 14 Oct 2026 09:30:00 GMT". The net/http package already knows how to write and read that format (including two older formats some clients still send), so we don't parse it by hand.
+
+Truncate(time.Second): A database timestamp like 09:30:00.250 becomes 09:30:00 in the header. Without truncating, the stored time would always look "newer" than the client's copy and the cache would never be used.
+
+Early return: NotModified only needs the last-modified time, which is usually a cheap query. The expensive work of loading and encoding the data is skipped whenever the client is already up to date.
+
+Important: Only GET and HEAD requests are answered with 304. For a POST or PUT, "not modified" makes no sense, so NotModified just sets the header and returns false.
+
+
+2. A Time Budget for the Whole Request
+--------------------------------------
+A common way to protect a handler is to give every database or HTTP call its own timeout:
+
+ctx, cancel := context.WithTimeout(r.Context(), 2*time.Second)
+
+But the timeouts add up. With three queries at 2 seconds each, a request can still take 6 seconds. And if the first query is slow, the other two still get their full 2 seconds, even though the client gave up long ago.
+
+A better model is a budget: the whole request gets, say, 3 seconds, and each step takes what it needs from what is left.
+
+import (
+    "context"
+    "time"
+)
+
+// WithBudget gives ctx a total deadline and returns a function reporting how
+// much of that budget is left. If ctx already has an earlier deadline, that
+// one wins. Call cancel once the work is done, as with context.WithTimeout.
+func WithBudget(ctx context.Context, total time.Duration) (context.Context, func() time.Duration, context.CancelFunc) {
+    ctx, cancel := context.WithTimeout(ctx, total)
+
+    remaining := func() time.Duration {
+        deadline, _ := ctx.Deadline()
+        if left := time.Until(deadline); left > 0 {
+            return left
+        }
+        return 0
+    }
+    return ctx, remaining, cancel
+}
+
+Using it:
+
+func getDashboard(w http.ResponseWriter, r *http.Request) {
+    ctx, remaining, cancel := WithBudget(r.Context(), 3*time.Second)
+    defer cancel()
+
+    // The main query may use up to half of what is left
+    qctx, qcancel := context.WithTimeout(ctx, remaining()/2)
+    stats, err := loadStats(qctx)
+    qcancel()
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusServiceUnavailable)
+        return
+    }
+
+    // The optional extras only run if there is real time left
+    if remaining() > 200*time.Millisecond {
+        stats.Recent, _ = loadRecentActivity(ctx)
+    }
+
+    json.NewEncoder(w).Encode(stats)
+}
+
+What's happening in that code?
+One deadline for everything: ctx carries the overall deadline. Any context derived from it, like qctx, can never outlive it, even if you ask for a longer timeout.
+
+remaining(): Calls time.Until on the deadline each time, so it always reflects how much time the earlier steps actually used.
+
+Fractions: Giving a step remaining()/2 instead of a fixed number keeps some time back for the steps that come after it, so one slow step can't use up the whole budget.
+
+cancel: context.WithTimeout starts a timer that lives until the deadline passes, the parent context ends, or cancel is called. WithBudget hands cancel back, so the handler can defer it like any other context and the timer stops the moment the handler returns, not only when net/http cancels the request context a little later.
+
+Important: When the budget runs out, every call using ctx fails with context.DeadlineExceeded. Check for it with errors.Is(err, context.DeadlineExceeded) and answer 503 or 504 rather than 500, since the server isn't broken, just out of time.
+
+
+3. Profiling a Running Server (pprof)
+-------------------------------------
+When a server is slow or uses too much memory, guessing is a waste of time. Go ships a profiler, net/http/pprof, that can tell you where the CPU time goes and what is holding on to memory, straight from the running program. The catch is that those pages reveal a lot about your program (including its command line), so they can't be open to the whole internet.
+
+PprofHandler serves them behind any check you like:
+
+import (
+    "net/http"
+    "net/http/pprof"
+)
+
+// PprofHandler serves the standard /debug/pprof/ pages, but only to
+// requests for which auth returns true. Everyone else gets 403.
+func PprofHandler(auth func(*http.Request) bool) http.Handler {
+    mux := http.NewServeMux()
+    mux.HandleFunc("/debug/pprof/", pprof.Index) // also serves heap, goroutine, allocs, ...
+    mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+    mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+    mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+    mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        if !auth(r) {
+            http.Error(w, "Forbidden", http.StatusForbidden)
+            return
+        }
+        mux.ServeHTTP(w, r)
+    })
+}
+
+Mount it, with a check of your choice:
+
+debugToken := MustEnv[string]("DEBUG_TOKEN") // configuration.go
+
+router.Handle("GET", "/debug/pprof/", PprofHandler(func(r *http.Request) bool {
+    return subtle.ConstantTimeCompare([]byte(r.Header.Get("X-Debug-Token")), []byte(debugToken)) == 1
+}))
+
+Then, from your laptop, download a profile and open it with go tool pprof:
+
+curl -H "X-Debug-Token: ..." -o cpu.prof "https://api.example.com/debug/pprof/profile?seconds=30"
+go tool pprof -http=:6060 cpu.prof      # opens a browser with graphs and flame charts
+
+curl -H "X-Debug-Token: ..." -o heap.prof https://api.example.com/debug/pprof/heap
+curl -H "X-Debug-Token: ..." "https://api.example.com/debug/pprof/goroutine?debug=2"   # every goroutine's stack, as text
+
+What's happening in that code?
+pprof.Index: Serves the overview page and also every named profile (heap, goroutine, allocs, block, mutex, threadcreate) based on the last part of the path. The other four need their own handlers.
+
+Its own ServeMux: The pprof handlers expect paths that start with /debug/pprof/, so they get a private mux. The auth check runs before the mux, which protects every page at once.
+
+subtle.ConstantTimeCompare: Compares the token without leaking, through timing, how many characters were right (see webhooks.go).
+
+Important: Importing net/http/pprof also registers these pages on http.DefaultServeMux, with no protection. If your server calls http.ListenAndServe(":8080", nil), the profiler is public. Always serve your own mux or Router when this package is imported anywhere in the program.
+
+
+4. Reading Headers into a Struct (BindHeaders)
+----------------------------------------------
+Handlers that need an API key, a page size and an Accept-Language usually start with a block of r.Header.Get calls, each followed by its own strconv and its own "is it missing?" check. BindHeaders does that work once. You describe the headers you want as a struct, and it fills the fields and converts the types.
+
+import (
+    "fmt"
+    "net/http"
+    "reflect"
+    "strconv"
+    "strings"
+    "time"
+)
+
+// BindHeaders fills a struct of type T from r's headers. Each field names its
+// header in a tag, optionally followed by ",required":
+//
+//    APIKey string `header:"X-Api-Key,required"`
+func BindHeaders[T any](r *http.Request) (T, error) {
+    var out T
+    v := reflect.ValueOf(&out).Elem()
+    if v.Kind() != reflect.Struct {
+        return out, fmt.Errorf("bind headers: %T is not a struct", out)
+    }
+
+    t := v.Type()
+    for i := range t.NumField() {
+        f := t.Field(i)
+        tag, ok := f.Tag.Lookup("header")
+        if !ok || tag == "-" || !f.IsExported() {
+            continue
+        }
+        name, opt, _ := strings.Cut(tag, ",")
+        values := r.Header.Values(name)
+        if len(values) == 0 {
+            if opt == "required" {
+                return out, fmt.Errorf("missing required header %s", name)
+            }
+            continue
+        }
+        if err := setHeaderField(v.Field(i), values); err != nil {
+            return out, fmt.Errorf("header %s: %w", name, err)
+        }
+    }
+    return out, nil
+}
+
+func setHeaderField(field reflect.Value, values []string) error {
+    if field.Kind() == reflect.Slice && field.Type().Elem().Kind() == reflect.String {
+        // Repeated headers and comma-separated lists mean the same thing
+        var list []string
+        for _, v := range values {
+            for _, part := range strings.Split(v, ",") {
+                if part = strings.TrimSpace(part); part != "" {
+                    list = append(list, part)
+                }
+            }
+        }
+        field.Set(reflect.ValueOf(list).Convert(field.Type()))
+        return nil
+    }
+
+    s := strings.TrimSpace(values[0])
+    if field.Type() == reflect.TypeFor[time.Duration]() {
+        d, err := time.ParseDuration(s)
+        if err != nil {
+            return err
+        }
+        field.SetInt(int64(d))
+        return nil
+    }
+
+    switch field.Kind() {
+    case reflect.String:
+        field.SetString(s)
+    case reflect.Bool:
+        b, err := strconv.ParseBool(s)
+        if err != nil {
+            return err
+        }
+        field.SetBool(b)
+    case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+        n, err := strconv.ParseInt(s, 10, field.Type().Bits())
+        if err != nil {
+            return err
+        }
+        field.SetInt(n)
+    case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+        n, err := strconv.ParseUint(s, 10, field.Type().Bits())
+        if err != nil {
+            return err
+        }
+        field.SetUint(n)
+    case reflect.Float32, reflect.Float64:
+        n, err := strconv.ParseFloat(s, field.Type().Bits())
+        if err != nil {
+            return err
+        }
+        field.SetFloat(n)
+    default:
+        return fmt.Errorf("unsupported field type %s", field.Type())
+    }
+    return nil
+}
+
+Using it:
+
+type listHeaders struct {
+    APIKey    string        `header:"X-Api-Key,required"`
+    PageSize  int           `header:"X-Page-Size"`
+    Languages []string      `header:"Accept-Language"`
+    Timeout   time.Duration `header:"X-Timeout"`
+}
+
+func listOrders(w http.ResponseWriter, r *http.Request) {
+    h, err := BindHeaders[listHeaders](r)
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusBadRequest)
+        return
+    }
+    if h.PageSize == 0 {
+        h.PageSize = 20 // not sent, so use the default
+    }
+    // ...
+}
+
+A request with "X-Page-Size: ten" gets 400 header X-Page-Size: strconv.ParseInt: parsing "ten": invalid syntax, and one without an API key gets 400 missing required header X-Api-Key.
+
+What's happening in that code?
+r.Header.Values: Header names are case-insensitive, and Values canonicalizes the name for us, so the tag can say X-Api-Key or x-api-key.
+
+Missing vs required: A header that wasn't sent leaves the field at its zero value, unless the tag says required. Then it's an error.
+
+Slices: A header can be sent several times, or once with a comma-separated list. For a []string field both are split into one list, so "Accept-Language: en, fr" and two separate headers give the same result.
+
+Important: Comma splitting is right for list headers like Accept, but wrong for the few headers whose values contain commas themselves (a date in If-Modified-Since, or a cookie). Read those into a plain string field. Only the first value is used for non-slice fields, so the client can't smuggle in a second API key to confuse you.
+
+
+5. A Readiness Endpoint That Doesn't Hammer Its Dependencies
+------------------------------------------------------------
+Kubernetes, load balancers and uptime monitors all want to know whether your service can take traffic. The usual answer is a /ready endpoint that checks the things the service can't work without: can it reach the database, is the cache up. The catch is how often it gets asked. Every load balancer node and every probe polls it every few seconds, and with ten instances behind three balancers that's a lot of pings the database has to answer for nothing.
+
+ReadinessHandler runs the checks at most once per interval and answers everyone else from the last result:
+
+import (
+    "context"
+    "database/sql"
+    "encoding/json"
+    "net/http"
+    "sync"
+    "sync/atomic"
+    "time"
+)
+
+// HealthCheck reports whether one dependency is usable.
+type HealthCheck func(ctx context.Context) error
+
+// PingCheck checks that the database answers.
+func PingCheck(db *sql.DB) HealthCheck {
+    return db.PingContext
+}
+
+// checkTimeout bounds a single check, so one hanging dependency can't hold
+// up the answer.
+const checkTimeout = 2 * time.Second
+
+type checkResult struct {
+    Status    string    `json:"status"`
+    Error     string    `json:"error,omitempty"`
+    CheckedAt time.Time `json:"checked_at"`
+    Duration  string    `json:"duration"`
+}
+
+type readinessReport struct {
+    Status string                 `json:"status"`
+    Checks map[string]checkResult `json:"checks"`
+    at     time.Time
+}
+
+type readiness struct {
+    checks   map[string]HealthCheck
+    interval time.Duration
+    report   atomic.Pointer[readinessReport]
+    mu       sync.Mutex  // held while the checks run
+    running  atomic.Bool // a background refresh is under way
+}
+
+// ReadinessHandler answers 200 if every check passes and 503 otherwise,
+// with each check's result as JSON. The checks run at most once per
+// interval; requests in between get the last result, and a stale result
+// is refreshed in the background.
+func ReadinessHandler(interval time.Duration, checks map[string]HealthCheck) http.Handler {
+    return &readiness{checks: checks, interval: interval}
+}
+
+func (h *readiness) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+    rep := h.report.Load()
+    switch age := h.age(rep); {
+    case rep == nil || age > 5*h.interval:
+        // Nothing yet, or so old that nobody should act on it: wait for fresh results
+        rep = h.refresh()
+    case age > h.interval:
+        if h.running.CompareAndSwap(false, true) {
+            go func() {
+                defer h.running.Store(false)
+                h.refresh()
+            }()
+        }
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    w.Header().Set("Cache-Control", "no-store")
+    if rep.Status != "ok" {
+        w.WriteHeader(http.StatusServiceUnavailable)
+    }
+    json.NewEncoder(w).Encode(rep)
+}
+
+func (h *readiness) age(rep *readinessReport) time.Duration {
+    if rep == nil {
+        return 0
+    }
+    return time.Since(rep.at)
+}
+
+// refresh runs all checks at once and stores the new report.
+func (h *readiness) refresh() *readinessReport {
+    h.mu.Lock()
+    defer h.mu.Unlock()
+    // Another request may have refreshed while we waited for the lock
+    if rep := h.report.Load(); rep != nil && h.age(rep) <= h.interval {
+        return rep
+    }
+
+    rep := &readinessReport{Status: "ok", Checks: make(map[string]checkResult, len(h.checks)), at: time.Now()}
+    var mu sync.Mutex
+    var wg sync.WaitGroup
+    for name, check := range h.checks {
+        wg.Add(1)
+        go func() {
+            defer wg.Done()
+            res := runCheck(check)
+            mu.Lock()
+            defer mu.Unlock()
+            rep.Checks[name] = res
+            if res.Status != "ok" {
+                rep.Status = "fail"
+            }
+        }()
+    }
+    wg.Wait()
+
+    h.report.Store(rep)
+    return rep
+}
+
+func runCheck(check HealthCheck) (res checkResult) {
+    // Not the request's context: the result is shared with other requests
+    ctx, cancel := context.WithTimeout(context.Background(), checkTimeout)
+    defer cancel()
+
+    start := time.Now()
+    defer func() {
+        if p := recover(); p != nil {
+            res = checkResult{Status: "fail", Error: "check panicked"}
+        }
+        res.CheckedAt = start.UTC()
+        res.Duration = time.Since(start).Round(time.Millisecond).String()
+    }()
+
+    if err := check(ctx); err != nil {
+        return checkResult{Status: "fail", Error: err.Error()}
+    }
+    return checkResult{Status: "ok"}
+}
+
+Using it:
+
+mux.Handle("GET /ready", ReadinessHandler(2*time.Second, map[string]HealthCheck{
+    "db":    PingCheck(db),
+    "cache": func(ctx context.Context) error { return rdb.Ping(ctx).Err() },
+    "disk": func(ctx context.Context) error {
+        if free := freeBytes("/var/data"); free < 1<<30 {
+            return fmt.Errorf("only %d MB free", free>>20)
+        }
+        return nil
+    },
+}))
+
+The answer (503 because the cache is down):
+
+{"status":"fail","checks":{
+  "cache":{"status":"fail","error":"dial tcp 10.0.0.7:6379: connect: connection refused","checked_at":"2024-05-01T09:00:02.004Z","duration":"2s"},
+  "db":{"status":"ok","checked_at":"2024-05-01T09:00:02.001Z","duration":"3ms"},
+  "disk":{"status":"ok","checked_at":"2024-05-01T09:00:02.001Z","duration":"0s"}}}
+
+What's happening in that code?
+Serving the cached verdict: Between refreshes a request costs one atomic load and a bit of JSON. However many probes ask, each check runs about once per interval.
+
+Refreshing in the background: When the result is older than interval, the request that notices starts a refresh in a new goroutine and still gets the cached answer right away, so a slow check never makes a probe time out. running makes sure there is only one such goroutine at a time. The only time a request waits is when there is no result yet (the first request) or it's more than five intervals old, because after a quiet minute an old "ok" is not worth much.
+
+No memory of the past: Each refresh builds a new report from the checks alone. A check that fails makes the next report "fail", with no "it was fine a second ago" smoothing in between, and the 503 goes out as soon as that refresh is done.
+
+Checks run in parallel: A hanging dependency costs at most checkTimeout, not checkTimeout times the number of checks. Each one gets its own context, not the request's, because its result is shared with every other request. One that panics is reported as failed instead of crashing the process.
+
+checked_at: Each result says when it was taken, so whoever reads the JSON can see how old the verdict is and which check was slow.
+
+Important: Readiness is not liveness. Kubernetes restarts a pod whose liveness probe fails, but only takes one out of the load balancer when readiness fails. Don't point the liveness probe at /ready: if the database goes down, every pod would be restarted at once, and that fixes nothing. A liveness endpoint should just answer 200 as long as the process can serve HTTP. Only check dependencies the service really can't work without; if it can serve stale data without the cache, leave the cache out.
+
+
+6. One Shape for Every List Endpoint (ListPage and Paginate)
+------------------------------------------------------------
+Without a rule, every list endpoint invents its own answer: one returns a bare array, one {"data":[...],"next":...}, one puts the cursor in a header. A client then needs different code for every list it reads. ListPage is the one envelope they all use, and Paginate fills it from a keyset query: instead of OFFSET (section 5 of database-recipes.go), each page starts after the last row of the previous one, which stays fast however deep the client scrolls.
+
+import (
+    "context"
+    "database/sql"
+    "encoding/base64"
+    "encoding/json"
+    "errors"
+    "net/http"
+    "slices"
+)
+
+// ListPage is the JSON envelope of every list endpoint.
+type ListPage[T any] struct {
+    Items      []T    `json:"items"`
+    NextCursor string `json:"next_cursor,omitempty"`
+    HasMore    bool   `json:"has_more"`
+}
+
+// WritePage sends items as a ListPage. next is the cursor of the following
+// page, or "" if this is the last one.
+func WritePage[T any](w http.ResponseWriter, items []T, next string) {
+    if items == nil {
+        items = []T{} // an empty list, not null
+    }
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(ListPage[T]{Items: items, NextCursor: next, HasMore: next != ""})
+}
+
+// ErrBadCursor means the client sent a cursor we didn't hand out.
+var ErrBadCursor = errors.New("invalid pagination cursor")
+
+const maxPageSize = 100
+
+// Paginate fetches one page of a keyset query. build gets the key of the
+// last row on the previous page ("" for the first page) and returns the
+// query, ordered by that key, without a LIMIT. scan reads one row and key
+// returns the row's key, which becomes the next cursor. build writes ?
+// placeholders; WithDialect rewrites them for the database.
+func Paginate[T any](ctx context.Context, db *sql.DB, cursor string, limit int,
+    build func(after string) (string, []any),
+    scan func(*sql.Rows) (T, error),
+    key func(T) string,
+    opts ...Option) (ListPage[T], error) {
+
+    after, err := base64.RawURLEncoding.DecodeString(cursor)
+    if err != nil {
+        return ListPage[T]{}, ErrBadCursor
+    }
+    limit = min(max(limit, 1), maxPageSize)
+
+    query, args := build(string(after))
+    query = newConfig(opts).dialect.Rebind(query + " LIMIT ?")
+    // One row more than asked for tells us whether there is a next page.
+    // Clone first: appending to args could write into build's own array.
+    rows, err := db.QueryContext(ctx, query, append(slices.Clone(args), limit+1)...)
+    if err != nil {
+        return ListPage[T]{}, err
+    }
+    defer rows.Close()
+
+    items := make([]T, 0, limit+1)
+    for rows.Next() {
+        item, err := scan(rows)
+        if err != nil {
+            return ListPage[T]{}, err
+        }
+        items = append(items, item)
+    }
+    if err := rows.Err(); err != nil {
+        return ListPage[T]{}, err
+    }
+
+    page := ListPage[T]{Items: items}
+    if len(items) > limit {
+        page.Items = items[:limit]
+        page.HasMore = true
+        page.NextCursor = base64.RawURLEncoding.EncodeToString([]byte(key(items[limit-1])))
+    }
+    return page, nil
+}
+
+Using it for the users list from connecting-to-databases.go:
+
+func listUsers(w http.ResponseWriter, r *http.Request) {
+    page, err := Paginate(r.Context(), db, r.URL.Query().Get("cursor"), 20,
+        func(after string) (string, []any) {
+            if after == "" {
+                return "SELECT id, name, email FROM users ORDER BY id", nil
+            }
+            return "SELECT id, name, email FROM users WHERE id > ? ORDER BY id", []any{after}
+        },
+        func(rows *sql.Rows) (User, error) {
+            var u User
+            err := rows.Scan(&u.ID, &u.Name, &u.Email)
+            return u, err
+        },
+        func(u User) string { return strconv.Itoa(u.ID) })
+
+    if errors.Is(err, ErrBadCursor) {
+        http.Error(w, err.Error(), http.StatusBadRequest)
+        return
+    }
+    if err != nil {
+        WriteError(w, err) // Error-handling.go
+        return
+    }
+    WritePage(w, page.Items, page.NextCursor)
+}
+
+The client gets:
+
+{"items":[{"id":21,"name":"Ann","email":"ann@example.com"}, ...],"next_cursor":"NDA","has_more":true}
+
+and asks for GET /users?cursor=NDA next, until has_more is false.
+
+What's happening in that code?
+One row extra: Paginate asks for limit+1 rows. If they all come back, there is at least one more page, and the extra row is cut off again. That's cheaper than a second COUNT query and works the same on every database.
+
+An opaque cursor: The cursor is the last key, base64-encoded. Clients should treat it as a token they pass back, not as an ID they build themselves, so that you can later change what's in it (a timestamp plus an ID, say) without breaking them. A cursor that doesn't decode is ErrBadCursor, which the handler turns into a 400.
+
+build gets the key: Only the query knows which column the key is and how to compare it, so build writes the WHERE part. The key goes in as a ? argument, never into the SQL text.
+
+Cloning args: If build returns a slice with spare capacity, say one it keeps in a variable and reuses, append(args, limit+1) would write limit+1 into build's array. slices.Clone gives Paginate its own copy first, so nothing outside changes.
+
+The envelope: ListPage always has items, even when empty ([] rather than null, which is what WritePage takes care of), and has_more is always there, so a client's loop is simply "while has_more". Items that come from somewhere else than Paginate, like a search service, go out through WritePage just the same.
+
+ORDER BY the key: Keyset pagination only works if the rows are ordered by the key alone, and the key is unique. "ORDER BY created_at" with two rows in the same second can skip one of them at a page break; order by (created_at, id) and put both in the cursor instead.
+
+Important: The envelope is called ListPage so it doesn't clash with the Page function in database-recipes.go, which builds LIMIT/OFFSET queries; both recipes can live in one package. Paginate appends " LIMIT ?" and passes the whole query through Dialect.Rebind (section 18 of database-recipes.go). On PostgreSQL, keep writing ? in build and pass WithDialect(Postgres) as the last argument; the placeholders, the LIMIT's included, come out as $1, $2, ....
+
+
+7. Checking Path Parameters Before Using Them (SanitizeParam)
+-------------------------------------------------------------
+A path parameter is whatever the client typed. r.PathValue("name") has already been URL-decoded, so GET /files/..%2F..%2Fetc%2Fpasswd arrives as "../../etc/passwd", and %00 arrives as a real null byte. A handler that joins that onto a directory, or writes it into a log line or a header, does what the client wants instead of what you meant. SanitizeParam rejects such values before any handler code sees them.
+
+import (
+    "errors"
+    "fmt"
+    "net/http"
+    "strings"
+    "unicode"
+    "unicode/utf8"
+)
+
+// ErrUnsafeParam is wrapped by every error SanitizeParam returns.
+var ErrUnsafeParam = errors.New("unsafe parameter")
+
+// SanitizeParam returns s unchanged if it is safe to use as a file name or
+// query argument, and an error wrapping ErrUnsafeParam if it contains a null
+// byte, a control character, invalid UTF-8, or a path-traversal sequence.
+func SanitizeParam(s string) (string, error) {
+    if !utf8.ValidString(s) {
+        return "", fmt.Errorf("%w: invalid UTF-8", ErrUnsafeParam)
+    }
+    for _, c := range s {
+        if c == 0 {
+            return "", fmt.Errorf("%w: contains a null byte", ErrUnsafeParam)
+        }
+        if unicode.IsControl(c) {
+            return "", fmt.Errorf("%w: contains control character %U", ErrUnsafeParam, c)
+        }
+    }
+    if strings.HasPrefix(s, "/") || strings.HasPrefix(s, `\`) {
+        return "", fmt.Errorf("%w: absolute path", ErrUnsafeParam)
+    }
+    // Windows accepts both separators, so check both everywhere
+    for _, part := range strings.FieldsFunc(s, func(c rune) bool { return c == '/' || c == '\\' }) {
+        if part == ".." {
+            return "", fmt.Errorf("%w: path traversal", ErrUnsafeParam)
+        }
+    }
+    if isDriveLetter(s) {
+        return "", fmt.Errorf("%w: drive letter", ErrUnsafeParam)
+    }
+    return s, nil
+}
+
+// isDriveLetter reports whether s starts like a Windows path: "C:", "C:\" or
+// "c:/". Other values with a colon, like "x:1" or "1:2", are fine.
+func isDriveLetter(s string) bool {
+    if len(s) < 2 || s[1] != ':' {
+        return false
+    }
+    c := s[0]
+    if !('a' <= c && c <= 'z' || 'A' <= c && c <= 'Z') {
+        return false
+    }
+    return len(s) == 2 || s[2] == '/' || s[2] == '\\'
+}
+
+// PathParam returns the sanitized path value name of r. If it is unsafe,
+// PathParam writes 400 Bad Request and returns false; the handler should
+// then return.
+func PathParam(w http.ResponseWriter, r *http.Request, name string) (string, bool) {
+    v, err := SanitizeParam(r.PathValue(name))
+    if err != nil {
+        http.Error(w, fmt.Sprintf("path parameter %s: %v", name, err), http.StatusBadRequest)
+        return "", false
+    }
+    return v, true
+}
+
+Using it for a handler that serves uploaded files:
+
+const uploadDir = "/var/lib/myapp/uploads"
+
+func getUpload(w http.ResponseWriter, r *http.Request) {
+    name, ok := PathParam(w, r, "name")
+    if !ok {
+        return // 400 already sent
+    }
+    http.ServeFile(w, r, filepath.Join(uploadDir, name))
+}
+
+router.HandleFunc("GET", "/files/{name...}", getUpload)
+
+and for any handler that takes an ID from the path, like getUser in connecting-to-databases.go:
+
+func getUser(w http.ResponseWriter, r *http.Request) {
+    id, ok := PathParam(w, r, "id")
+    if !ok {
+        return
+    }
+    row := db.QueryRowContext(r.Context(), "SELECT id, name, email FROM users WHERE id = ?", id)
+    ...
+}
+
+$ curl -i 'localhost:8080/files/..%2F..%2Fetc%2Fpasswd'
+HTTP/1.1 400 Bad Request
+path parameter name: unsafe parameter: path traversal
+
+$ curl -i 'localhost:8080/files/report%00.pdf'
+HTTP/1.1 400 Bad Request
+path parameter name: unsafe parameter: contains a null byte
+
+What's happening in that code?
+Rejecting, not cleaning: SanitizeParam never tries to repair a value (by stripping "../", say). Stripping is easy to get wrong: removing "../" once from "....//" leaves "../" behind. A file name with ".." in it is never a legitimate request, so refusing it is simpler and safer.
+
+Parts, not substrings: "..", "a/../b" and "a\..\b" are rejected, but "v1..v2" and "notes..txt" are not, because only a path element that is exactly ".." climbs a directory.
+
+Drive letters: On Windows, C:\secret.txt is an absolute path, and code that passes the value to os.Open on its own, without joining it to a directory first, reads whatever it names. Only a letter followed by a colon and then a separator (or nothing) is rejected, so IDs like "x:1" or "1:2" still get through.
+
+Control characters: Apart from the null byte (which C-based libraries and some file systems treat as the end of the string), newlines and other control characters are what log injection and header splitting are made of. None of them belong in an ID or a file name.
+
+PathParam: It follows the same pattern as DecodeJSON in Error-handling.go: on bad input it writes the 400 itself and returns false, so the handler is just "if !ok { return }". errors.Is(err, ErrUnsafeParam) is there for code that calls SanitizeParam directly and wants its own response.
+
+Important: SanitizeParam is one layer, not the whole defence. Queries still take the value as a ? argument, never by building SQL with it, and file access should still be confined to its directory: since Go 1.24, os.OpenRoot(uploadDir) returns an *os.Root whose Open refuses to leave the directory, even through a symlink. http.FileServer(http.Dir(...)) already rejects ".." on its own, so there the check matters for the handlers around it, not for the file server itself.
@@ -0,0 +1,1355 @@
+HTTP Middleware in Go
+=====================
+
+Middleware is code that runs before and/or after every handler: logging, authentication, recovering from panics, compression. In Go there is no special framework for this. A middleware is just a function that takes an http.Handler and returns a new http.Handler that wraps it:
+
+func MyMiddleware(next http.Handler) http.Handler {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        // before the handler
+        next.ServeHTTP(w, r)
+        // after the handler
+    })
+}
+
+Because they all have the same shape, you can stack as many as you like:
+
+http.ListenAndServe(":8080", Logger(mux))
+
+
+1. Logging Requests (and Failed Writes)
+---------------------------------------
+A request logger wants to print the status code and the size of the response. The problem is that http.ResponseWriter doesn't let you read those back after the handler has run. The trick is to wrap the ResponseWriter in our own type that remembers them as they pass through.
+
+While we're at it, the wrapper also remembers the first error Write returned. Handlers almost never check the result of w.Write or json.NewEncoder(w).Encode, so when a client disconnects halfway through a response the "broken pipe" error just disappears. With the wrapper it gets logged.
+
+import "net/http"
+
+// responseRecorder wraps a ResponseWriter and remembers what was sent.
+type responseRecorder struct {
+    http.ResponseWriter
+    status  int
+    bytes   int
+    err     error // first error returned by Write
+    started bool  // headers have been sent, the status can't change anymore
+}
+
+func newResponseRecorder(w http.ResponseWriter) *responseRecorder {
+    return &responseRecorder{ResponseWriter: w, status: http.StatusOK}
+}
+
+func (rec *responseRecorder) WriteHeader(code int) {
+    rec.status = code
+    rec.started = true
+    rec.ResponseWriter.WriteHeader(code)
+}
+
+func (rec *responseRecorder) Write(b []byte) (int, error) {
+    rec.started = true
+    n, err := rec.ResponseWriter.Write(b)
+    rec.bytes += n
+    if err != nil && rec.err == nil {
+        rec.err = err
+    }
+    return n, err
+}
+
+// Err returns the first write error, e.g. "broken pipe" when the client left.
+func (rec *responseRecorder) Err() error {
+    return rec.err
+}
+
+// Flush keeps streaming handlers (like SSE) working through the wrapper.
+func (rec *responseRecorder) Flush() {
+    if f, ok := rec.ResponseWriter.(http.Flusher); ok {
+        f.Flush()
+    }
+}
+
+// Unwrap lets http.ResponseController reach the original writer.
+func (rec *responseRecorder) Unwrap() http.ResponseWriter {
+    return rec.ResponseWriter
+}
+
+The middleware itself:
+
+import (
+    "net/http"
+    "time"
+
+    "myapp/applog"
+)
+
+// Logger logs every request through applog.Default().
+func Logger(next http.Handler) http.Handler {
+    return LoggerWith(applog.Default())(next)
+}
+
+// LoggerWith is Logger with a logger of your choice.
+func LoggerWith(l applog.Logger) func(http.Handler) http.Handler {
+    return accessLog(func(r *http.Request, rec *responseRecorder, d time.Duration) {
+        l.Info("request", "method", r.Method, "path", r.URL.Path,
+            "status", rec.status, "bytes", rec.bytes, "duration", d)
+        if err := rec.Err(); err != nil {
+            l.Warn("response write failed", "method", r.Method, "path", r.URL.Path, "err", err)
+        }
+    })
+}
+
+// accessLog does the wrapping and timing shared by every access logger.
+// emit is called once the handler has finished.
+func accessLog(emit func(r *http.Request, rec *responseRecorder, d time.Duration)) func(http.Handler) http.Handler {
+    return func(next http.Handler) http.Handler {
+        return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+            start := time.Now()
+            rec := newResponseRecorder(w)
+
+            next.ServeHTTP(rec, r)
+
+            emit(r, rec, time.Since(start))
+        })
+    }
+}
+
+Output looks like this:
+
+2026/10/14 09:30:01 INFO request method=GET path=/users status=200 bytes=5120 duration=3.2ms
+2026/10/14 09:30:04 INFO request method=GET path=/export status=200 bytes=65536 duration=2.1s
+2026/10/14 09:30:04 WARN response write failed method=GET path=/export err="write tcp 127.0.0.1:8080->127.0.0.1:51234: write: broken pipe"
+
+What's happening in that code?
+Embedding: responseRecorder embeds http.ResponseWriter, so it automatically has Header() and every other method. We only override the two we care about.
+
+The default status: If a handler never calls WriteHeader, Go sends 200 OK on the first Write. That's why the recorder starts with status set to http.StatusOK.
+
+LoggerWith: Logger sends its lines to applog.Default(). To send them somewhere else, wrap with LoggerWith(myLogger) instead (see logging.go).
+
+Only the first error: Once a connection is broken every later Write fails with the same kind of error. The first one is the interesting one.
+
+Flush and Unwrap: Wrapping a ResponseWriter hides any extra methods the original had. Without Flush, streaming handlers such as the SSE hub in streaming-responses.go would stop working behind the logger.
+
+Important: Any other middleware that wraps the ResponseWriter (a gzip compressor, for example) should go through the same recorder, or at least record its Write errors the same way. Otherwise it hides the error again.
+
+
+2. Access Logs as JSON
+----------------------
+Log collectors like Loki, Elasticsearch, or CloudWatch can search and chart your logs much better when each line is a JSON object with fixed field names, instead of text they have to pick apart. JSONLogger writes one such object per request.
+
+It doesn't copy the wrapping code from Logger. Both loggers are built on accessLog, which does the wrapping and timing, and only differ in how they print the result (Logger now uses it too, see above).
+
+import (
+    "encoding/json"
+    "io"
+    "net"
+    "net/http"
+    "sync"
+    "time"
+)
+
+type accessLogEntry struct {
+    Timestamp  time.Time `json:"timestamp"`
+    Method     string    `json:"method"`
+    Path       string    `json:"path"`
+    Status     int       `json:"status"`
+    DurationMS float64   `json:"duration_ms"`
+    Bytes      int       `json:"bytes"`
+    RequestID  string    `json:"request_id,omitempty"`
+    RemoteIP   string    `json:"remote_ip"`
+    Error      string    `json:"error,omitempty"`
+}
+
+// JSONLogger writes one JSON object per request to out, e.g. os.Stdout.
+func JSONLogger(out io.Writer) func(http.Handler) http.Handler {
+    var mu sync.Mutex // requests finish concurrently, lines must not interleave
+    enc := json.NewEncoder(out)
+
+    return accessLog(func(r *http.Request, rec *responseRecorder, d time.Duration) {
+        entry := accessLogEntry{
+            Timestamp:  time.Now().UTC(),
+            Method:     r.Method,
+            Path:       r.URL.Path,
+            Status:     rec.status,
+            DurationMS: float64(d.Microseconds()) / 1000,
+            Bytes:      rec.bytes,
+            RequestID:  requestID(r, rec),
+            RemoteIP:   remoteIP(r),
+        }
+        if err := rec.Err(); err != nil {
+            entry.Error = err.Error()
+        }
+
+        mu.Lock()
+        defer mu.Unlock()
+        enc.Encode(entry)
+    })
+}
+
+// requestID prefers the ID the client (or a proxy) sent, then one a handler
+// or earlier middleware set on the response.
+func requestID(r *http.Request, w http.ResponseWriter) string {
+    if id := r.Header.Get("X-Request-ID"); id != "" {
+        return id
+    }
+    return w.Header().Get("X-Request-ID")
+}
+
+func remoteIP(r *http.Request) string {
+    host, _, err := net.SplitHostPort(r.RemoteAddr)
+    if err != nil {
+        return r.RemoteAddr
+    }
+    return host
+}
+
+Using it:
+
+handler := JSONLogger(os.Stdout)(router)
+log.Fatal(http.ListenAndServe(":8080", handler))
+
+Each request produces a line like:
+
+{"timestamp":"2026-10-14T09:30:01.52Z","method":"GET","path":"/users","status":200,"duration_ms":3.214,"bytes":5120,"request_id":"7f3c9a","remote_ip":"203.0.113.9"}
+
+What's happening in that code?
+A fixed struct: Using a struct instead of a map means the fields always come out in the same order with the same names, which is what log pipelines expect. duration_ms is a float so fast requests don't all show up as 0.
+
+The mutex: Many requests finish at the same time. Encode writes each line with a single Write, but the mutex guarantees two lines can never be mixed together, whatever out is.
+
+request_id: If a load balancer or the client sent an X-Request-ID header we log it. That lets you follow one request through every service it touched.
+
+remote_ip: r.RemoteAddr is "ip:port". We only keep the IP. Behind a proxy this is the proxy's address, so don't trust headers like X-Forwarded-For unless the proxy is yours.
+
+Important: Put the logger outermost, JSONLogger(otherMiddleware(router)), so it records the status and size the client actually received after every other middleware has had its say.
+
+
+3. Recovering from Panics (and Retrying the Harmless Ones)
+----------------------------------------------------------
+A panic in a handler doesn't crash the server. net/http recovers it, logs the stack trace and simply closes the connection. The client sees "connection reset" or "empty reply", no status code at all, and nothing in your access log. Recover catches the panic one step earlier, so the client gets a proper 500 and the log gets the stack.
+
+Some panics aren't bugs in your code. A driver or library that panics on a transient problem (a dropped pooled connection, for example) would succeed if the request simply ran again. RetryOnPanic does that, for the panics you pick.
+
+It uses the started flag that responseRecorder now tracks (section 1), and writeJSONError from routing.go.
+
+import (
+    "bytes"
+    "io"
+    "net/http"
+    "runtime/debug"
+
+    "myapp/applog"
+)
+
+// RecoverOption configures Recover.
+type RecoverOption func(*recoverConfig)
+
+type recoverConfig struct {
+    logger  applog.Logger
+    retries int
+    matches func(recovered any) bool
+}
+
+func RecoverLogger(l applog.Logger) RecoverOption {
+    return func(c *recoverConfig) { c.logger = l }
+}
+
+// RetryOnPanic runs the handler again, up to n more times, when it panics
+// with a value matches accepts. Only idempotent requests are retried.
+// matches must not be nil; RetryOnPanic panics at setup if it is.
+func RetryOnPanic(n int, matches func(recovered any) bool) RecoverOption {
+    if matches == nil {
+        panic("recover: RetryOnPanic needs a matches function")
+    }
+    return func(c *recoverConfig) { c.retries, c.matches = n, matches }
+}
+
+// Recover turns a panicking handler into a 500 response and a log line
+// with the stack trace, instead of a dropped connection.
+func Recover(opts ...RecoverOption) func(http.Handler) http.Handler {
+    cfg := recoverConfig{logger: applog.Default()}
+    for _, opt := range opts {
+        opt(&cfg)
+    }
+
+    return func(next http.Handler) http.Handler {
+        return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+            if cfg.retries > 0 && idempotent(r.Method) {
+                serveWithRetry(cfg, next, w, r)
+                return
+            }
+
+            rec := newResponseRecorder(w)
+            if p, stack := callHandler(next, rec, r); p != nil {
+                cfg.logger.Error("handler panicked", "method", r.Method, "path", r.URL.Path,
+                    "panic", p, "stack", string(stack))
+                if !rec.started {
+                    writeJSONError(w, CodeInternal, "internal server error")
+                }
+            }
+        })
+    }
+}
+
+// serveWithRetry buffers each attempt, so a panicking one leaves no trace in
+// the real response, and only the successful attempt is sent.
+func serveWithRetry(cfg recoverConfig, next http.Handler, w http.ResponseWriter, r *http.Request) {
+    // Every attempt needs the full body again
+    body, err := io.ReadAll(r.Body)
+    if err != nil {
+        writeJSONError(w, CodeValidation, "could not read request body")
+        return
+    }
+
+    for attempt := 0; ; attempt++ {
+        r.Body = io.NopCloser(bytes.NewReader(body))
+        buf := newBufferedResponse()
+        p, stack := callHandler(next, buf, r)
+        if p == nil {
+            buf.sendTo(w)
+            return
+        }
+        if attempt < cfg.retries && cfg.matches(p) {
+            cfg.logger.Warn("handler panicked, retrying", "method", r.Method, "path", r.URL.Path,
+                "attempt", attempt+1, "panic", p)
+            continue
+        }
+        cfg.logger.Error("handler panicked", "method", r.Method, "path", r.URL.Path,
+            "attempts", attempt+1, "panic", p, "stack", string(stack))
+        writeJSONError(w, CodeInternal, "internal server error")
+        return
+    }
+}
+
+// callHandler runs h and returns what it panicked with, if anything.
+func callHandler(h http.Handler, w http.ResponseWriter, r *http.Request) (recovered any, stack []byte) {
+    defer func() {
+        if recovered = recover(); recovered != nil {
+            if recovered == http.ErrAbortHandler {
+                panic(recovered) // net/http's way to abort a response on purpose
+            }
+            stack = debug.Stack()
+        }
+    }()
+    h.ServeHTTP(w, r)
+    return nil, nil
+}
+
+func idempotent(method string) bool {
+    switch method {
+    case http.MethodGet, http.MethodHead, http.MethodPut, http.MethodDelete, http.MethodOptions:
+        return true
+    }
+    return false
+}
+
+// bufferedResponse collects a whole response in memory.
+type bufferedResponse struct {
+    header http.Header
+    status int
+    body   bytes.Buffer
+}
+
+func newBufferedResponse() *bufferedResponse {
+    return &bufferedResponse{header: make(http.Header), status: http.StatusOK}
+}
+
+func (b *bufferedResponse) Header() http.Header { return b.header }
+
+func (b *bufferedResponse) WriteHeader(code int) { b.status = code }
+
+func (b *bufferedResponse) Write(p []byte) (int, error) { return b.body.Write(p) }
+
+func (b *bufferedResponse) sendTo(w http.ResponseWriter) {
+    // A copy for every writer: SingleFlight sends one response to many, and
+    // a middleware appending to one writer's header mustn't change the others
+    for key, values := range b.header.Clone() {
+        w.Header()[key] = values
+    }
+    w.WriteHeader(b.status)
+    w.Write(b.body.Bytes())
+}
+
+Using it:
+
+// The library panics with a *pool.ConnError when a pooled connection broke
+isPoolHiccup := func(recovered any) bool {
+    _, ok := recovered.(*pool.ConnError)
+    return ok
+}
+
+handler := Logger(Recover(RetryOnPanic(2, isPoolHiccup))(router))
+log.Fatal(http.ListenAndServe(":8080", handler))
+
+What's happening in that code?
+recover in a deferred function: recover only works inside a deferred call. callHandler puts it around h.ServeHTTP and hands back the panic value and the stack trace, taken with debug.Stack() while we are still inside the panicking goroutine's defer.
+
+500 only if nothing was sent: Once a handler has written its status or part of the body, the status can't be changed anymore. In that case the log line is all we can add, and the client gets the cut-off response.
+
+Buffering for retries: An attempt that panics may already have written half a page. With retries on, every attempt writes into a bufferedResponse, and only the attempt that finishes is copied to the real ResponseWriter. The request body is read once up front, since the first attempt would otherwise consume it.
+
+Idempotent methods only: GET, HEAD, PUT, DELETE and OPTIONS mean the same thing when sent twice. A POST that panicked after inserting a row would insert it again, so POST and PATCH are never retried, whatever matches says.
+
+http.ErrAbortHandler: A handler panics with this value on purpose to abort a response, and net/http handles it quietly. Recover passes it on instead of turning it into a 500.
+
+A nil matches fails at startup: serveWithRetry calls matches while it is handling a panic, and calling a nil function there panics again, outside callHandler, so the client gets a dropped connection instead of a 500. RetryOnPanic checks it once, when the middleware chain is built, so the mistake shows up the first time the program starts. To retry every panic, say so: func(any) bool { return true }.
+
+Important: Keep Recover inside Logger, so the access log records the 500. With retries on, responses are buffered in memory, which breaks streaming handlers like SSE and costs memory for large downloads, so put RetryOnPanic only on the routes that need it. Make matches as narrow as possible. Retrying a nil pointer dereference just panics two more times.
+
+
+4. Where Did the Time Go? (Trace and Server-Timing)
+---------------------------------------------------
+The access log says GET /reports took 840ms. It doesn't say whether that was the database, the JSON encoding, or a call to another service. A Trace lets a handler time its own steps, and the Tracing middleware reports them, both in the log and in a Server-Timing header that browser dev tools show in the network tab, under Timing.
+
+import (
+    "context"
+    "fmt"
+    "net/http"
+    "strings"
+    "sync"
+    "time"
+)
+
+// Trace collects how long the named steps of one request took.
+type Trace struct {
+    mu    sync.Mutex
+    spans []span
+}
+
+type span struct {
+    name string
+    dur  time.Duration
+}
+
+type traceKey struct{}
+
+// TraceFrom returns the request's Trace. Without the Tracing middleware it
+// returns nil, and a nil *Trace still works, it just records nothing.
+func TraceFrom(ctx context.Context) *Trace {
+    t, _ := ctx.Value(traceKey{}).(*Trace)
+    return t
+}
+
+// Span starts timing name; call the returned func to stop:
+//
+//    defer TraceFrom(ctx).Span("db")()
+func (t *Trace) Span(name string) func() {
+    if t == nil {
+        return func() {}
+    }
+    start := time.Now()
+    return func() {
+        t.mu.Lock()
+        t.spans = append(t.spans, span{name: name, dur: time.Since(start)})
+        t.mu.Unlock()
+    }
+}
+
+// serverTiming formats the finished spans as a Server-Timing header value,
+// e.g. "db;dur=12.4, encode;dur=0.3".
+func (t *Trace) serverTiming() string {
+    t.mu.Lock()
+    defer t.mu.Unlock()
+    parts := make([]string, len(t.spans))
+    for i, s := range t.spans {
+        name := strings.ReplaceAll(s.name, " ", "_") // must be a single token
+        parts[i] = fmt.Sprintf("%s;dur=%.1f", name, float64(s.dur.Microseconds())/1000)
+    }
+    return strings.Join(parts, ", ")
+}
+
+// Tracing puts a Trace in every request's context. The spans finished before
+// the response starts go out in a Server-Timing header (browsers show it in
+// the network tab), and all of them are logged at debug level at the end.
+func Tracing(opts ...Option) func(http.Handler) http.Handler {
+    l := newConfig(opts).logger
+
+    return func(next http.Handler) http.Handler {
+        return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+            t := &Trace{}
+            start := time.Now()
+            tw := &timingWriter{ResponseWriter: w, trace: t}
+
+            next.ServeHTTP(tw, r.WithContext(context.WithValue(r.Context(), traceKey{}, t)))
+
+            l.Debug("request trace", "method", r.Method, "path", r.URL.Path,
+                "total", time.Since(start), "spans", t.serverTiming())
+        })
+    }
+}
+
+// timingWriter adds the Server-Timing header just before the headers go out,
+// which is the last moment a header can still be set.
+type timingWriter struct {
+    http.ResponseWriter
+    trace       *Trace
+    wroteHeader bool
+}
+
+func (tw *timingWriter) WriteHeader(code int) {
+    if !tw.wroteHeader {
+        tw.wroteHeader = true
+        if v := tw.trace.serverTiming(); v != "" {
+            tw.Header().Set("Server-Timing", v)
+        }
+    }
+    tw.ResponseWriter.WriteHeader(code)
+}
+
+func (tw *timingWriter) Write(b []byte) (int, error) {
+    if !tw.wroteHeader {
+        tw.WriteHeader(http.StatusOK)
+    }
+    return tw.ResponseWriter.Write(b)
+}
+
+func (tw *timingWriter) Flush() {
+    if !tw.wroteHeader {
+        tw.WriteHeader(http.StatusOK)
+    }
+    if f, ok := tw.ResponseWriter.(http.Flusher); ok {
+        f.Flush()
+    }
+}
+
+func (tw *timingWriter) Unwrap() http.ResponseWriter {
+    return tw.ResponseWriter
+}
+
+Using it:
+
+func getReport(w http.ResponseWriter, r *http.Request) {
+    trace := TraceFrom(r.Context())
+
+    endQuery := trace.Span("db")
+    rows, err := loadReportRows(r.Context())
+    endQuery()
+    if err != nil {
+        WriteError(w, err)
+        return
+    }
+
+    endBuild := trace.Span("build")
+    report := buildReport(rows)
+    endBuild()
+
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(report)
+}
+
+handler := Logger(Tracing(WithLogger(logger))(router))
+
+The response carries:
+
+Server-Timing: db;dur=812.4, build;dur=3.1
+
+What's happening in that code?
+A func to stop the span: Span records the start time and returns a closure that records the duration. With defer trace.Span("x")() the span covers the rest of the function, and the explicit endQuery() form covers just a few lines.
+
+A nil *Trace is fine: Methods in Go can be called on a nil pointer. Span checks for nil and returns a function that does nothing, so handlers don't need an if, and they still work in tests or on routes without the middleware.
+
+Setting the header in time: Headers can't change once the handler starts writing the body. timingWriter adds Server-Timing in WriteHeader, the last moment possible. Spans that end later (like the encoding above, if you time it) are missing from the header but still in the debug log line.
+
+The mutex: A handler may time steps in several goroutines at once, for example when it runs queries in parallel, so appending to the spans is locked.
+
+Important: Server-Timing tells every client how long your queries take, which is information an attacker could use too. Only add Tracing for internal traffic, or strip the header at the edge for public requests.
+
+
+5. Safe Retries for POST (Idempotency Keys)
+-------------------------------------------
+A client sends POST /payments, and the connection drops before the response arrives. Did the payment go through? The client can't know. If it retries, the customer may pay twice; if it doesn't, they may not pay at all. Idempotency keys solve this: the client makes up a unique key for each operation (a UUID) and sends it in an Idempotency-Key header, the same key on every retry. The server runs the first request and remembers its response under the key. A retry with the same key doesn't run the handler again, it just gets the stored response.
+
+The middleware needs somewhere to keep the responses. In production that's usually a table or Redis, shared by all instances, so it's an interface:
+
+import (
+    "bytes"
+    "context"
+    "crypto/sha256"
+    "encoding/hex"
+    "errors"
+    "io"
+    "net/http"
+    "sync"
+    "time"
+)
+
+// ErrKeyInFlight is returned by IdempotencyStore.Claim while another
+// request with the same key is still running.
+var ErrKeyInFlight = errors.New("idempotency key is in use by a running request")
+
+// StoredResponse is a finished response, kept to answer retries with.
+type StoredResponse struct {
+    Fingerprint string // hash of the request it answered
+    Status      int
+    Header      http.Header
+    Body        []byte
+}
+
+// IdempotencyStore remembers responses by idempotency key. With several
+// instances of the service, it has to be shared (a database table, Redis).
+type IdempotencyStore interface {
+    // Claim marks key as in progress and returns nil, nil. If key already
+    // has a response, Claim returns it instead. If another request holds
+    // the key, it returns ErrKeyInFlight.
+    Claim(ctx context.Context, key string) (*StoredResponse, error)
+    // Complete stores the response for a claimed key.
+    Complete(ctx context.Context, key string, resp *StoredResponse) error
+    // Release drops a claim without a response, so the key can be retried.
+    Release(ctx context.Context, key string) error
+}
+
+// Idempotency makes POST, PUT, PATCH and DELETE requests with an
+// Idempotency-Key header safe to retry: the first request runs, and
+// every retry with the same key gets the first response again.
+func Idempotency(store IdempotencyStore, opts ...Option) func(http.Handler) http.Handler {
+    logger := newConfig(opts).logger
+
+    return func(next http.Handler) http.Handler {
+        return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+            key := r.Header.Get("Idempotency-Key")
+            if key == "" || !mutating(r.Method) {
+                next.ServeHTTP(w, r)
+                return
+            }
+
+            body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, 1<<20))
+            if err != nil {
+                writeJSONError(w, CodeTooLarge, "request body too large")
+                return
+            }
+            r.Body = io.NopCloser(bytes.NewReader(body))
+            fingerprint := requestFingerprint(r, body)
+
+            ctx := r.Context()
+            stored, err := store.Claim(ctx, key)
+            switch {
+            case errors.Is(err, ErrKeyInFlight):
+                w.Header().Set("Retry-After", "1")
+                writeJSONError(w, CodeConflict, "a request with this Idempotency-Key is still in progress")
+                return
+            case err != nil:
+                logger.Error("idempotency store failed", "key", key, "err", err)
+                writeJSONError(w, CodeInternal, "internal server error")
+                return
+            case stored != nil:
+                if stored.Fingerprint != fingerprint {
+                    writeJSONError(w, CodeUnprocessable, "Idempotency-Key was already used for a different request")
+                    return
+                }
+                replay(w, stored)
+                return
+            }
+
+            // We hold the claim. Give it up again if the handler panics.
+            completed := false
+            defer func() {
+                if !completed {
+                    store.Release(context.WithoutCancel(ctx), key)
+                }
+            }()
+
+            buf := newBufferedResponse()
+            next.ServeHTTP(buf, r)
+            buf.sendTo(w)
+
+            if buf.status >= 500 {
+                return // a server error isn't an answer; let the client retry
+            }
+            resp := &StoredResponse{
+                Fingerprint: fingerprint,
+                Status:      buf.status,
+                Header:      buf.header.Clone(),
+                Body:        buf.body.Bytes(),
+            }
+            if err := store.Complete(context.WithoutCancel(ctx), key, resp); err != nil {
+                logger.Error("idempotency store failed", "key", key, "err", err)
+                return
+            }
+            completed = true
+        })
+    }
+}
+
+func mutating(method string) bool {
+    switch method {
+    case http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete:
+        return true
+    }
+    return false
+}
+
+// requestFingerprint identifies what a request asked for, so a key reused
+// for a different request is caught.
+func requestFingerprint(r *http.Request, body []byte) string {
+    h := sha256.New()
+    io.WriteString(h, r.Method+" "+r.URL.RequestURI()+"\n")
+    h.Write(body)
+    return hex.EncodeToString(h.Sum(nil))
+}
+
+func replay(w http.ResponseWriter, stored *StoredResponse) {
+    for key, values := range stored.Header {
+        w.Header()[key] = values
+    }
+    w.Header().Set("Idempotent-Replayed", "true")
+    w.WriteHeader(stored.Status)
+    w.Write(stored.Body)
+}
+
+// MemoryIdempotencyStore keeps responses in memory for ttl. It is
+// enough for a single instance, and for tests.
+type MemoryIdempotencyStore struct {
+    ttl       time.Duration
+    mu        sync.Mutex
+    entries   map[string]*idempotencyEntry
+    lastSweep time.Time
+}
+
+type idempotencyEntry struct {
+    resp    *StoredResponse // nil while the request is running
+    expires time.Time
+}
+
+func NewMemoryIdempotencyStore(ttl time.Duration) *MemoryIdempotencyStore {
+    return &MemoryIdempotencyStore{ttl: ttl, entries: make(map[string]*idempotencyEntry)}
+}
+
+func (s *MemoryIdempotencyStore) Claim(ctx context.Context, key string) (*StoredResponse, error) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    // Drop expired responses, but not on every call
+    if now := time.Now(); now.Sub(s.lastSweep) > time.Minute {
+        for k, e := range s.entries {
+            if e.resp != nil && now.After(e.expires) {
+                delete(s.entries, k)
+            }
+        }
+        s.lastSweep = now
+    }
+
+    if e, ok := s.entries[key]; ok && (e.resp == nil || time.Now().Before(e.expires)) {
+        if e.resp == nil {
+            return nil, ErrKeyInFlight
+        }
+        return e.resp, nil
+    }
+    s.entries[key] = &idempotencyEntry{}
+    return nil, nil
+}
+
+func (s *MemoryIdempotencyStore) Complete(ctx context.Context, key string, resp *StoredResponse) error {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    s.entries[key] = &idempotencyEntry{resp: resp, expires: time.Now().Add(s.ttl)}
+    return nil
+}
+
+func (s *MemoryIdempotencyStore) Release(ctx context.Context, key string) error {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    delete(s.entries, key)
+    return nil
+}
+
+Using it:
+
+store := NewMemoryIdempotencyStore(24 * time.Hour)
+
+mux.HandleFunc("POST /payments", createPayment)
+handler := Logger(Recover()(Idempotency(store)(mux)))
+
+A client's first attempt and its retry:
+
+curl -X POST -H 'Idempotency-Key: 7f9c1e2a-...' -d '{"amount":1200}' localhost:8080/payments
+201 {"id":"pay_123","amount":1200}
+
+curl -X POST -H 'Idempotency-Key: 7f9c1e2a-...' -d '{"amount":1200}' localhost:8080/payments
+201 {"id":"pay_123","amount":1200}       (Idempotent-Replayed: true, nothing was charged)
+
+What's happening in that code?
+Claim is the lock: Checking "is there a response?" and "mark it as running" has to be one step. If it were two, two retries arriving at the same moment could both see nothing and both run the handler. The memory store does both under one mutex; a database store would use an INSERT that fails on a duplicate key.
+
+409 while in flight: A retry that arrives while the first request is still running gets a 409 with Retry-After, not a second execution and not a long wait. By the time the client asks again, the stored response is usually there.
+
+The fingerprint: A key belongs to one request. If a client (by a bug) sends the same key with a different body or to a different URL, replaying the old response would be wrong, and running the new request would break the promise. requestFingerprint hashes the method, URL and body, and a mismatch gets a 422.
+
+Buffering the response: The handler writes into a bufferedResponse (from section 3), which is then sent to the client and stored. The body is read up front for the same reason: the fingerprint needs it, and r.Body is replaced with a fresh reader so the handler can still read it.
+
+What gets stored: 2xx and 4xx responses are final answers: a retry of a request that failed validation should fail the same way. A 5xx is not stored and the claim is released, so the client can try again and maybe succeed. If the handler panics, the deferred Release runs on the way out, before Recover turns the panic into a 500.
+
+context.WithoutCancel: If the client hangs up right at the end, r.Context() is cancelled. The response must still be stored (that's exactly the case the client will retry), so the store calls don't use the request's cancellation.
+
+Important: Keys are only unique per client, not globally. Put Idempotency after your authentication middleware and have that middleware rewrite the header to the user ID plus the key (r.Header.Set("Idempotency-Key", userID+":"+key)), or one user could replay another user's response by guessing their key. A shared store needs an expiry on claims as well: if an instance crashes mid-request, its claim would block the key forever, so give in-flight entries a lease of a few minutes. Stored responses should live at least as long as clients keep retrying; 24 hours is common. Leave streaming endpoints out, since the whole response is held in memory.
+
+
+6. Recording Requests to Replay Them Later (Record and Replay)
+--------------------------------------------------------------
+"It fails for some customers, but I can't reproduce it." The access log from section 2 says which request failed, but not what was in it: the exact body, the headers, what the handler answered. Record keeps a copy of a sample of requests together with their responses. Later you feed a recorded request to your handler again with Replay, in a test or on your laptop, and watch it fail in front of you.
+
+import (
+    "bytes"
+    "context"
+    "io"
+    "math/rand/v2"
+    "net/http"
+    "net/http/httptest"
+    "strings"
+    "sync"
+    "time"
+)
+
+// maxRecordedBody caps each stored body; anything longer is cut off.
+const maxRecordedBody = 64 << 10
+
+// Headers that are stored as [REDACTED] and left out on replay.
+var sensitiveHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie", "X-Api-Key"}
+
+const redacted = "[REDACTED]"
+
+// RecordedRequest is one request and its response, as Record captured them.
+type RecordedRequest struct {
+    Time     time.Time     `json:"time"`
+    Duration time.Duration `json:"duration"`
+
+    Method        string      `json:"method"`
+    URL           string      `json:"url"` // path and query
+    Header        http.Header `json:"header"`
+    Body          string      `json:"body"`
+    BodyTruncated bool        `json:"body_truncated,omitempty"`
+
+    Status            int         `json:"status"`
+    ResponseHeader    http.Header `json:"response_header"`
+    ResponseBody      string      `json:"response_body"`
+    ResponseTruncated bool        `json:"response_truncated,omitempty"`
+}
+
+// RecordStore keeps recorded requests.
+type RecordStore interface {
+    Save(ctx context.Context, rec RecordedRequest) error
+}
+
+// Record captures about sampleRate of all requests (0.01 is one in a
+// hundred) with their responses and saves them to store.
+func Record(store RecordStore, sampleRate float64, opts ...Option) func(http.Handler) http.Handler {
+    logger := newConfig(opts).logger
+
+    return func(next http.Handler) http.Handler {
+        return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+            if rand.Float64() >= sampleRate {
+                next.ServeHTTP(w, r)
+                return
+            }
+
+            rec := RecordedRequest{
+                Time:   time.Now(),
+                Method: r.Method,
+                URL:    r.URL.RequestURI(),
+                Header: redactHeaders(r.Header),
+            }
+
+            // Read the start of the body now, and hand the handler the full body
+            head, err := io.ReadAll(io.LimitReader(r.Body, maxRecordedBody+1))
+            if err != nil {
+                writeJSONError(w, CodeValidation, "could not read request body")
+                return
+            }
+            r.Body = readCloser{io.MultiReader(bytes.NewReader(head), r.Body), r.Body}
+            rec.Body, rec.BodyTruncated = capBody(head)
+
+            rw := &recordingWriter{ResponseWriter: w, status: http.StatusOK}
+            next.ServeHTTP(rw, r)
+
+            rec.Duration = time.Since(rec.Time)
+            rec.Status = rw.status
+            rec.ResponseHeader = redactHeaders(w.Header())
+            rec.ResponseBody, rec.ResponseTruncated = rw.body.String(), rw.truncated
+
+            if err := store.Save(context.WithoutCancel(r.Context()), rec); err != nil {
+                logger.Warn("record request failed", "method", r.Method, "path", r.URL.Path, "err", err)
+            }
+        })
+    }
+}
+
+// Replay sends a recorded request to h again and returns what h answered.
+// Redacted headers are left out; set real credentials on h's side if the
+// route needs them.
+func Replay(h http.Handler, recorded RecordedRequest) *httptest.ResponseRecorder {
+    req := httptest.NewRequest(recorded.Method, recorded.URL, strings.NewReader(recorded.Body))
+    for key, values := range recorded.Header {
+        if len(values) == 1 && values[0] == redacted {
+            continue
+        }
+        req.Header[key] = values
+    }
+
+    rec := httptest.NewRecorder()
+    h.ServeHTTP(rec, req)
+    return rec
+}
+
+func redactHeaders(h http.Header) http.Header {
+    out := h.Clone()
+    for _, key := range sensitiveHeaders {
+        if out.Get(key) != "" {
+            out.Set(key, redacted)
+        }
+    }
+    return out
+}
+
+func capBody(b []byte) (string, bool) {
+    if len(b) > maxRecordedBody {
+        return string(b[:maxRecordedBody]), true
+    }
+    return string(b), false
+}
+
+type readCloser struct {
+    io.Reader
+    io.Closer
+}
+
+// recordingWriter passes the response through and keeps a copy of the
+// start of the body.
+type recordingWriter struct {
+    http.ResponseWriter
+    status    int
+    body      bytes.Buffer
+    truncated bool
+}
+
+func (rw *recordingWriter) WriteHeader(code int) {
+    rw.status = code
+    rw.ResponseWriter.WriteHeader(code)
+}
+
+func (rw *recordingWriter) Write(p []byte) (int, error) {
+    if room := maxRecordedBody - rw.body.Len(); room < len(p) {
+        rw.body.Write(p[:max(room, 0)])
+        rw.truncated = true
+    } else {
+        rw.body.Write(p)
+    }
+    return rw.ResponseWriter.Write(p)
+}
+
+func (rw *recordingWriter) Flush() {
+    if f, ok := rw.ResponseWriter.(http.Flusher); ok {
+        f.Flush()
+    }
+}
+
+func (rw *recordingWriter) Unwrap() http.ResponseWriter {
+    return rw.ResponseWriter
+}
+
+// RingRecordStore keeps the last n recorded requests in memory.
+type RingRecordStore struct {
+    mu   sync.Mutex
+    recs []RecordedRequest
+    next int
+    full bool
+}
+
+func NewRingRecordStore(n int) *RingRecordStore {
+    return &RingRecordStore{recs: make([]RecordedRequest, n)}
+}
+
+func (s *RingRecordStore) Save(ctx context.Context, rec RecordedRequest) error {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    s.recs[s.next] = rec
+    s.next = (s.next + 1) % len(s.recs)
+    if s.next == 0 {
+        s.full = true
+    }
+    return nil
+}
+
+// All returns the stored requests, oldest first.
+func (s *RingRecordStore) All() []RecordedRequest {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    if !s.full {
+        return append([]RecordedRequest(nil), s.recs[:s.next]...)
+    }
+    return append(append([]RecordedRequest(nil), s.recs[s.next:]...), s.recs[:s.next]...)
+}
+
+Recording one request in a hundred, and a debug endpoint to look at them:
+
+records := NewRingRecordStore(500)
+
+handler := Logger(Record(records, 0.01)(mux))
+
+admin.HandleFunc("GET /debug/requests", func(w http.ResponseWriter, r *http.Request) {
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(records.All())
+})
+
+Turning a recorded failure into a test: save the JSON of the request in testdata/, and replay it against the real router:
+
+func TestOrderWithEmptyCart(t *testing.T) {
+    data, err := os.ReadFile("testdata/order-500.json")
+    if err != nil {
+        t.Fatal(err)
+    }
+    var recorded RecordedRequest
+    if err := json.Unmarshal(data, &recorded); err != nil {
+        t.Fatal(err)
+    }
+
+    rec := Replay(newRouter(testDeps(t)), recorded)
+    if rec.Code != http.StatusBadRequest {
+        t.Fatalf("got %d %s, want 400", rec.Code, rec.Body)
+    }
+}
+
+What's happening in that code?
+Sampling: rand.Float64() < sampleRate decides per request, so 0.01 records about one in a hundred and 1 records everything. The other requests only pay for one random number.
+
+Reading the body twice: The body can only be read once. Record reads the first 64KB into head and gives the handler a new Body that returns head first and then the rest of the original, so the handler sees exactly what the client sent. The Close still goes to the original body.
+
+Capping the response: recordingWriter passes every write straight on, so streaming responses still stream, and keeps only the first 64KB. BodyTruncated and ResponseTruncated say when something was cut off, so nobody wonders why the JSON ends in the middle.
+
+Redacted, not dropped: Authorization and Cookie values are replaced with [REDACTED] instead of being removed, so you can still see that the request was authenticated. Replay leaves those headers out. If the route needs a login, wrap h in a test middleware that sets the user.
+
+Bodies as strings: The fields are strings rather than []byte, so the JSON from the debug endpoint shows the bodies as text instead of base64, and you can edit a recording by hand.
+
+Important: Redacting headers is not enough to make a recording safe. Bodies contain whatever your users send (passwords on a login route, addresses, payment details), query strings can carry tokens, and responses can contain personal data too. Leave authentication routes out of Record entirely, keep the store somewhere only the team can read, and delete recordings once the bug is fixed. The recorded request runs against your current code and your current data, so a replay shows you how the request behaves today, which is not always how it behaved then.
+
+
+7. One Handler Run for Many Identical Requests (SingleFlight)
+-------------------------------------------------------------
+A dashboard that 200 people have open refreshes every 30 seconds, and its report takes two seconds to build. When the refreshes line up, the server builds the same report dozens of times at once, each copy slowing the others down. Memoize (generics.go) and CachedDB (database-recipes.go) solve this for one function or one query. SingleFlight does it for a whole HTTP response: while a request for a key is running, the others with the same key wait for it and get a copy of its response.
+
+import (
+    "net/http"
+    "sync"
+    "time"
+)
+
+// singleFlightWait caps how long a request waits for a shared response
+// before it runs the handler itself.
+const singleFlightWait = 5 * time.Second
+
+type flight struct {
+    done chan struct{}     // closed when the leader is finished
+    resp *bufferedResponse // nil if the response can't be shared
+}
+
+// SingleFlight runs the handler once for concurrent GET and HEAD requests
+// with the same key, and sends its response to all of them. Requests with
+// an empty key, and all other methods, are served normally.
+func SingleFlight(key func(*http.Request) string) func(http.Handler) http.Handler {
+    var mu sync.Mutex
+    flights := make(map[string]*flight)
+
+    return func(next http.Handler) http.Handler {
+        return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+            k := key(r)
+            if k == "" || (r.Method != http.MethodGet && r.Method != http.MethodHead) {
+                next.ServeHTTP(w, r)
+                return
+            }
+            k = r.Method + " " + k
+
+            mu.Lock()
+            if f, ok := flights[k]; ok {
+                mu.Unlock()
+                waitForFlight(f, next, w, r)
+                return
+            }
+            f := &flight{done: make(chan struct{})}
+            flights[k] = f
+            mu.Unlock()
+
+            // Whatever happens, even a panic, let the waiters go
+            defer func() {
+                mu.Lock()
+                delete(flights, k)
+                mu.Unlock()
+                close(f.done)
+            }()
+
+            buf := newBufferedResponse()
+            next.ServeHTTP(buf, r)
+            buf.sendTo(w)
+
+            // A response cut short by our own client leaving, or one that sets
+            // cookies for this client, is no good for anybody else
+            if r.Context().Err() == nil && len(buf.header.Values("Set-Cookie")) == 0 {
+                f.resp = buf
+            }
+        })
+    }
+}
+
+func waitForFlight(f *flight, next http.Handler, w http.ResponseWriter, r *http.Request) {
+    timer := time.NewTimer(singleFlightWait)
+    defer timer.Stop()
+
+    select {
+    case <-f.done:
+        if f.resp != nil {
+            w.Header().Set("X-Shared-Response", "true")
+            f.resp.sendTo(w)
+            return
+        }
+    case <-timer.C:
+    case <-r.Context().Done():
+        return // the client is gone, nobody to answer
+    }
+    next.ServeHTTP(w, r) // nothing to share, or it took too long: do it ourselves
+}
+
+Using it for the reports, keyed by the full URL:
+
+byURL := func(r *http.Request) string { return r.URL.RequestURI() }
+
+mux.Handle("GET /reports/{id}", SingleFlight(byURL)(http.HandlerFunc(getReport)))
+
+For data that differs per user, the key must include the user:
+
+byUserAndURL := func(r *http.Request) string {
+    user, ok := UserFrom(r.Context()) // set by your auth middleware
+    if !ok {
+        return "" // no key: served normally
+    }
+    return user.ID + " " + r.URL.RequestURI()
+}
+
+What's happening in that code?
+The leader and the waiters: The first request for a key creates a flight and runs the handler. Everyone who arrives with the same key while it runs finds the flight in the map and waits for done. Once the leader is finished, the flight is removed, so the next request runs the handler again: nothing is cached beyond that moment.
+
+Only safe methods: A GET or HEAD should not change anything, so answering three GETs with one result is the same as answering them one by one. Two POSTs are two orders, and both have to go through. The method is part of the internal key, so a HEAD never receives a GET's body.
+
+The capped wait: A waiter gives up after singleFlightWait and runs the handler itself. A leader stuck on a slow query holds back only itself, not everyone behind it. A waiter whose client disconnects stops waiting right away.
+
+When a response isn't shared: If the leader panics, the deferred function still removes the flight and closes done, but resp stays nil, so each waiter runs the handler on its own (and any panic is its own, for Recover to handle). The same happens when the leader's client left halfway through, which may have cut the response short, and when the response sets a cookie, which belongs to one client only.
+
+A header for each waiter: sendTo gives every writer its own header.Clone() instead of the leader's slices. Otherwise a middleware that adds a value to one waiter's header (a Vary, a second Set-Cookie) could append into an array all the waiters share, and the others would send it too.
+
+The key decides: Everything that changes the response must be in the key. Leave out the user, and one user gets another user's data; leave out the query string, and ?page=2 gets page 1.
+
+Important: The leader's response is buffered before it is sent, so SingleFlight doesn't fit streaming endpoints. It only helps with requests that overlap in time. If they arrive one after another, each one runs the handler, so for data that can be a few seconds old, put a cache (with Cache-Control, or CachedDB) in front as well. The X-Shared-Response header makes shared answers easy to spot when debugging.
+
+
+8. API Versions in the URL or a Header (APIVersion)
+---------------------------------------------------
+Sooner or later an endpoint has to change in a way old clients can't handle: a field renamed, a list that becomes paginated. The mobile app from two releases ago is still out there, though, so both versions have to work at the same time. Clients say which one they want, either in the path (/v2/orders) or in a header (API-Version: v2), and the server answers accordingly.
+
+APIVersion reads the version from either place, checks it against the versions you still support, and puts it into the request context. Handlers are registered once, without the prefix, and branch where the versions actually differ:
+
+import (
+    "context"
+    "fmt"
+    "net/http"
+    "net/url"
+    "slices"
+    "strings"
+)
+
+type versionKey struct{}
+
+// VersionFromContext returns the API version the APIVersion middleware
+// picked for the request, or "" without the middleware.
+func VersionFromContext(ctx context.Context) string {
+    v, _ := ctx.Value(versionKey{}).(string)
+    return v
+}
+
+// APIVersion reads the requested API version from a URL prefix (/v2/users)
+// or from the header named header (API-Version: v2), and answers 400 if it
+// isn't one of supported. A URL prefix is removed before next sees the
+// path, so the routes don't repeat it. Requests without a version get
+// supported[0], so list the oldest version first: clients written before
+// versioning existed expect it.
+func APIVersion(supported []string, header string) func(http.Handler) http.Handler {
+    if len(supported) == 0 {
+        panic("api version: no supported versions")
+    }
+    return func(next http.Handler) http.Handler {
+        return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+            fromHeader := r.Header.Get(header)
+            fromPath, rest, inPath := versionPrefix(r.URL.Path)
+
+            version := supported[0]
+            switch {
+            case inPath && fromHeader != "" && fromHeader != fromPath:
+                writeJSONError(w, CodeValidation,
+                    fmt.Sprintf("API version %q in the URL but %q in the %s header", fromPath, fromHeader, header))
+                return
+            case inPath:
+                version = fromPath
+            case fromHeader != "":
+                version = fromHeader
+            }
+            if !slices.Contains(supported, version) {
+                writeJSONError(w, CodeValidation,
+                    fmt.Sprintf("unsupported API version %q, supported: %s", version, strings.Join(supported, ", ")))
+                return
+            }
+
+            if inPath {
+                r = stripPath(r, rest)
+            }
+            w.Header().Set(header, version) // tell the client which version answered
+            w.Header().Add("Vary", header)
+            next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), versionKey{}, version)))
+        })
+    }
+}
+
+// versionPrefix splits "/v2/users" into "v2" and "/users". A first segment
+// that doesn't look like a version (/videos) is left alone.
+func versionPrefix(path string) (version, rest string, ok bool) {
+    seg, rest, _ := strings.Cut(strings.TrimPrefix(path, "/"), "/")
+    if len(seg) < 2 || seg[0] != 'v' || strings.Trim(seg[1:], "0123456789.") != "" {
+        return "", path, false
+    }
+    return seg, "/" + rest, true
+}
+
+// stripPath returns a shallow copy of r with path as its URL path, the way
+// http.StripPrefix does it.
+func stripPath(r *http.Request, path string) *http.Request {
+    r2 := new(http.Request)
+    *r2 = *r
+    r2.URL = new(url.URL)
+    *r2.URL = *r.URL
+    r2.URL.Path = path
+    r2.URL.RawPath = ""
+    return r2
+}
+
+Using it:
+
+mux := http.NewServeMux()
+mux.HandleFunc("GET /orders", listOrders)
+
+http.ListenAndServe(":8080", APIVersion([]string{"v1", "v2"}, "API-Version")(mux))
+
+func listOrders(w http.ResponseWriter, r *http.Request) {
+    orders, next := loadOrders(r)
+    if VersionFromContext(r.Context()) == "v1" {
+        w.Header().Set("Content-Type", "application/json")
+        json.NewEncoder(w).Encode(orders) // v1: a plain array
+        return
+    }
+    WritePage(w, orders, next) // v2: a Page, see http-handler-helpers.go
+}
+
+GET /v2/orders, and GET /orders with API-Version: v2, both reach listOrders with "v2". GET /orders alone gets v1, GET /v3/orders gets 400 with {"error":{"code":"validation_failed","message":"unsupported API version \"v3\", supported: v1, v2"}}.
+
+What's happening in that code?
+The path prefix: versionPrefix only treats the first segment as a version if it's a v followed by digits (v2, v10, v2.1), so /videos/1 is left alone. stripPath removes the prefix from a copy of the request, the same way http.StripPrefix does, so the mux sees /orders for every version.
+
+A conflict is an error: A request for /v1/orders with API-Version: v2 usually means a client library adds the header by itself and someone wrote the URL by hand. Guessing which one they meant would hide the bug, so it's a 400 that names both.
+
+The default: A request without any version gets supported[0]. Clients written before versioning existed were written against the first version, and they keep working without a change.
+
+The response says which version answered: The version header is set on the response as well, which makes logs and bug reports unambiguous. Vary tells caches that the same URL has a different response per value of that header, so a cache doesn't hand a v1 client a v2 body.
+
+Errors as JSON: The 400 uses writeJSONError from routing.go, so clients get the same error format as everywhere else in the API.
+
+Important: Only introduce a new version for changes that break clients. Adding a field, a new endpoint or an optional parameter is backward-compatible and needs no new version. Each supported version is code you keep testing, so announce when an old one goes away, and remove it from supported only then; from that day its clients get a 400 that says which versions are left. Put APIVersion outside the mux, as above: the prefix has to be stripped before the mux matches the route.
+
+
+9. Accepting Compressed Request Bodies (DecompressRequest)
+----------------------------------------------------------
+Compression usually goes the other way, server to client, but some clients compress what they send too: a mobile app uploading a day of offline changes, or a service posting large batches of events. They set Content-Encoding: gzip on the request, and json.NewDecoder(r.Body) in the handler then fails on the first byte of binary gzip data.
+
+DecompressRequest unpacks the body before the handler sees it. Because a few kilobytes of gzip can expand to gigabytes (a "zip bomb"), it also puts a hard limit on how large the unpacked body may get:
+
+import (
+    "bytes"
+    "compress/gzip"
+    "compress/zlib"
+    "fmt"
+    "io"
+    "net/http"
+    "strconv"
+    "strings"
+)
+
+// maxDecompressedBody is the most a compressed request body may expand to.
+const maxDecompressedBody = 10 << 20 // 10MB
+
+// DecompressRequest undoes a gzip or deflate Content-Encoding on the request
+// body, so handlers read plain JSON whatever the client sent. A body that
+// expands beyond maxDecompressedBody, or isn't valid, gets 400; any other
+// encoding gets 415.
+func DecompressRequest(next http.Handler) http.Handler {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        var (
+            zr  io.ReadCloser
+            err error
+        )
+        switch enc := strings.ToLower(strings.TrimSpace(r.Header.Get("Content-Encoding"))); enc {
+        case "", "identity":
+            next.ServeHTTP(w, r)
+            return
+        case "gzip", "x-gzip":
+            zr, err = gzip.NewReader(r.Body)
+        case "deflate":
+            zr, err = zlib.NewReader(r.Body) // HTTP's "deflate" is the zlib format
+        default:
+            writeJSONError(w, CodeUnsupported, fmt.Sprintf("unsupported Content-Encoding %q", enc))
+            return
+        }
+        if err != nil {
+            writeJSONError(w, CodeValidation, "invalid compressed body")
+            return
+        }
+        defer zr.Close()
+
+        // Read one byte more than allowed, to tell "exactly at the limit"
+        // from "over it"
+        body, err := io.ReadAll(io.LimitReader(zr, maxDecompressedBody+1))
+        switch {
+        case err != nil:
+            writeJSONError(w, CodeValidation, "invalid compressed body")
+            return
+        case len(body) > maxDecompressedBody:
+            writeJSONError(w, CodeValidation,
+                fmt.Sprintf("request body is larger than %d bytes once decompressed", maxDecompressedBody))
+            return
+        }
+
+        r.Body = io.NopCloser(bytes.NewReader(body))
+        r.ContentLength = int64(len(body))
+        r.Header.Set("Content-Length", strconv.Itoa(len(body)))
+        r.Header.Del("Content-Encoding")
+        next.ServeHTTP(w, r)
+    })
+}
+
+Using it:
+
+mux.HandleFunc("POST /events", saveEvents) // decodes r.Body as JSON, unchanged
+
+http.ListenAndServe(":8080", DecompressRequest(mux))
+
+A client sending gzip:
+
+var buf bytes.Buffer
+zw := gzip.NewWriter(&buf)
+json.NewEncoder(zw).Encode(events)
+zw.Close() // writes the gzip trailer; without it the server sees a truncated body
+
+req, _ := http.NewRequestWithContext(ctx, "POST", "https://api.example.com/events", &buf)
+req.Header.Set("Content-Type", "application/json")
+req.Header.Set("Content-Encoding", "gzip")
+
+What's happening in that code?
+Only what was asked for: A request without Content-Encoding (or with identity) goes straight to the handler, so the middleware costs nothing for normal requests. gzip and deflate have readers in the standard library. Anything else, like br, gets 415 Unsupported Media Type, which tells a client exactly which part of its request to change.
+
+The one-byte trick: io.LimitReader(zr, maxDecompressedBody+1) stops reading after one byte more than the limit, so a bomb is cut off after 10MB, however far it would expand. If ReadAll got more than maxDecompressedBody bytes, the body is over the limit; exactly maxDecompressedBody is still fine. It's the same trick section 3 of json-recipes.go uses for plain bodies.
+
+Unpacked before the handler: Decompressing everything first is what makes a clean 400 possible. If the handler read through the gzip reader itself, the limit could only be hit halfway through json.Decode, after the handler might have done part of its work. Since the limit is 10MB, holding the body in memory is bounded too.
+
+Headers that match the body: Content-Encoding is removed and Content-Length set to the unpacked size, so anything later in the chain, like Record from section 6 or a handler that checks r.ContentLength, sees a plain request.
+
+deflate means zlib: In HTTP, "deflate" is the zlib format (RFC 1950), deflate data with a small header and a checksum, so DecompressRequest uses compress/zlib. A client that sends raw deflate without the header gets a 400 "invalid compressed body".
+
+Important: The limit on the unpacked size doesn't limit how much compressed data a client may send. Put an http.MaxBytesReader on r.Body in a middleware before DecompressRequest for that, or a limit in the proxy in front of the server. Put DecompressRequest outside Idempotency and SingleFlight, so their fingerprints and keys are computed over the same plain bytes whether or not the client compressed them.
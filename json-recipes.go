@@ -0,0 +1,1238 @@
+JSON Recipes
+============
+
+communicating-using-json.go covers the everyday tools: struct tags, json.Marshal, and json.Unmarshal. This file collects helpers for the harder cases that show up once your API has real clients: partial updates, hostile input, and output that has to look exactly right.
+
+
+1. JSON Patch (RFC 6902)
+------------------------
+A PUT request replaces a whole resource. Often a client only wants to change one field, like fixing the title of a Book. RFC 6902 defines a standard format for that, sent with Content-Type: application/json-patch+json. It is a list of operations:
+
+[
+  {"op": "replace", "path": "/title", "value": "The Go Programming Language"},
+  {"op": "add",     "path": "/tags/-", "value": "classic"},
+  {"op": "remove",  "path": "/draft"},
+  {"op": "test",    "path": "/author", "value": "Alan Donovan"}
+]
+
+Paths are JSON Pointers (RFC 6901): "/tags/0" means the first element of the "tags" array, and "/tags/-" means "after the last element". There are six operations: add, remove, replace, move, copy, and test. test changes nothing. It makes the whole patch fail if a value isn't what the client expected, which protects against two people editing at the same time.
+
+import (
+    "bytes"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "strconv"
+    "strings"
+)
+
+var (
+    ErrPathNotFound = errors.New("path not found")
+    ErrTestFailed   = errors.New("test failed")
+)
+
+// In a JSON Pointer "~1" stands for "/" and "~0" for "~"
+var pointerUnescaper = strings.NewReplacer("~1", "/", "~0", "~")
+
+type patchOp struct {
+    Op    string          `json:"op"`
+    Path  string          `json:"path"`
+    From  string          `json:"from"`
+    Value json.RawMessage `json:"value"`
+}
+
+// ApplyPatch applies an RFC 6902 JSON Patch to doc. The operations run in
+// order and the first failing one stops the whole patch.
+func ApplyPatch(doc []byte, patch []byte) ([]byte, error) {
+    var ops []patchOp
+    if err := json.Unmarshal(patch, &ops); err != nil {
+        return nil, fmt.Errorf("json patch: invalid patch: %w", err)
+    }
+    root, err := decodeValue(doc)
+    if err != nil {
+        return nil, fmt.Errorf("json patch: invalid document: %w", err)
+    }
+
+    for i, op := range ops {
+        root, err = applyOp(root, op)
+        if err != nil {
+            return nil, fmt.Errorf("json patch: operation %d (%s %s): %w", i, op.Op, op.Path, err)
+        }
+    }
+    return json.Marshal(root)
+}
+
+func applyOp(root any, op patchOp) (any, error) {
+    path, err := parsePointer(op.Path)
+    if err != nil {
+        return nil, err
+    }
+
+    switch op.Op {
+    case "add", "replace", "test":
+        if op.Value == nil {
+            return nil, errors.New(`missing "value"`)
+        }
+        value, err := decodeValue(op.Value)
+        if err != nil {
+            return nil, err
+        }
+        switch op.Op {
+        case "add":
+            return add(root, path, value)
+        case "replace":
+            return replace(root, path, value)
+        }
+        current, err := get(root, path)
+        if err != nil {
+            return nil, err
+        }
+        if !jsonEqual(current, value) {
+            return nil, ErrTestFailed
+        }
+        return root, nil
+
+    case "remove":
+        root, _, err = remove(root, path)
+        return root, err
+
+    case "move", "copy":
+        from, err := parsePointer(op.From)
+        if err != nil {
+            return nil, err
+        }
+        if op.Op == "copy" {
+            value, err := get(root, from)
+            if err != nil {
+                return nil, err
+            }
+            return add(root, path, deepCopy(value))
+        }
+        if strings.HasPrefix(op.Path+"/", op.From+"/") && op.Path != op.From {
+            return nil, errors.New("cannot move a value into one of its own children")
+        }
+        root, value, err := remove(root, from)
+        if err != nil {
+            return nil, err
+        }
+        return add(root, path, value)
+    }
+    return nil, fmt.Errorf("unknown op %q", op.Op)
+}
+
+// parsePointer splits a JSON Pointer like "/books/0/title" into its tokens.
+func parsePointer(p string) ([]string, error) {
+    if p == "" {
+        return nil, nil // the whole document
+    }
+    if !strings.HasPrefix(p, "/") {
+        return nil, fmt.Errorf("invalid path %q: must start with /", p)
+    }
+    tokens := strings.Split(p[1:], "/")
+    for i, t := range tokens {
+        tokens[i] = pointerUnescaper.Replace(t)
+    }
+    return tokens, nil
+}
+
+func get(node any, path []string) (any, error) {
+    for _, token := range path {
+        var err error
+        if node, err = child(node, token); err != nil {
+            return nil, err
+        }
+    }
+    return node, nil
+}
+
+func add(root any, path []string, value any) (any, error) {
+    if len(path) == 0 {
+        return value, nil
+    }
+    return update(root, path, func(parent any, key string) (any, error) {
+        switch p := parent.(type) {
+        case map[string]any:
+            p[key] = value
+            return p, nil
+        case []any:
+            if key == "-" {
+                return append(p, value), nil
+            }
+            i, err := arrayIndex(key, len(p)+1) // inserting at len(p) appends
+            if err != nil {
+                return nil, err
+            }
+            p = append(p, nil)
+            copy(p[i+1:], p[i:])
+            p[i] = value
+            return p, nil
+        }
+        return nil, ErrPathNotFound
+    })
+}
+
+func replace(root any, path []string, value any) (any, error) {
+    if len(path) == 0 {
+        return value, nil
+    }
+    return update(root, path, func(parent any, key string) (any, error) {
+        if _, err := child(parent, key); err != nil {
+            return nil, err
+        }
+        return setChild(parent, key, value), nil
+    })
+}
+
+// remove deletes the value at path and also returns it (used by move).
+func remove(root any, path []string) (any, any, error) {
+    if len(path) == 0 {
+        return nil, nil, errors.New("cannot remove the whole document")
+    }
+    var removed any
+    root, err := update(root, path, func(parent any, key string) (any, error) {
+        var err error
+        if removed, err = child(parent, key); err != nil {
+            return nil, err
+        }
+        switch p := parent.(type) {
+        case map[string]any:
+            delete(p, key)
+            return p, nil
+        case []any:
+            i, _ := arrayIndex(key, len(p))
+            return append(p[:i], p[i+1:]...), nil
+        }
+        return nil, ErrPathNotFound
+    })
+    return root, removed, err
+}
+
+// update walks down to the parent of the last token, lets leaf change it, and
+// stores the changed parent back on the way up (appending to a slice can
+// return a new slice, so changes have to be written back).
+func update(node any, path []string, leaf func(parent any, key string) (any, error)) (any, error) {
+    if len(path) == 1 {
+        return leaf(node, path[0])
+    }
+    next, err := child(node, path[0])
+    if err != nil {
+        return nil, err
+    }
+    next, err = update(next, path[1:], leaf)
+    if err != nil {
+        return nil, err
+    }
+    return setChild(node, path[0], next), nil
+}
+
+func child(node any, key string) (any, error) {
+    switch n := node.(type) {
+    case map[string]any:
+        if v, ok := n[key]; ok {
+            return v, nil
+        }
+    case []any:
+        i, err := arrayIndex(key, len(n))
+        if err != nil {
+            return nil, err
+        }
+        return n[i], nil
+    }
+    return nil, fmt.Errorf("%w: %q", ErrPathNotFound, key)
+}
+
+func setChild(node any, key string, value any) any {
+    switch n := node.(type) {
+    case map[string]any:
+        n[key] = value
+    case []any:
+        i, _ := arrayIndex(key, len(n))
+        n[i] = value
+    }
+    return node
+}
+
+// arrayIndex parses an array index that must be below limit. Leading zeros
+// ("01") are not allowed by the RFC.
+func arrayIndex(key string, limit int) (int, error) {
+    i, err := strconv.Atoi(key)
+    if err != nil || i < 0 || i >= limit || (len(key) > 1 && key[0] == '0') {
+        return 0, fmt.Errorf("%w: invalid array index %q", ErrPathNotFound, key)
+    }
+    return i, nil
+}
+
+func decodeValue(data []byte) (any, error) {
+    dec := json.NewDecoder(bytes.NewReader(data))
+    dec.UseNumber() // keep numbers exactly as written, e.g. large IDs
+    var v any
+    if err := dec.Decode(&v); err != nil {
+        return nil, err
+    }
+    return v, nil
+}
+
+func jsonEqual(a, b any) bool {
+    switch x := a.(type) {
+    case json.Number:
+        y, ok := b.(json.Number)
+        if !ok {
+            return false
+        }
+        fx, errX := x.Float64()
+        fy, errY := y.Float64()
+        return errX == nil && errY == nil && fx == fy // 1 and 1.0 are equal
+    case map[string]any:
+        y, ok := b.(map[string]any)
+        if !ok || len(x) != len(y) {
+            return false
+        }
+        for k, v := range x {
+            if w, ok := y[k]; !ok || !jsonEqual(v, w) {
+                return false
+            }
+        }
+        return true
+    case []any:
+        y, ok := b.([]any)
+        if !ok || len(x) != len(y) {
+            return false
+        }
+        for i := range x {
+            if !jsonEqual(x[i], y[i]) {
+                return false
+            }
+        }
+        return true
+    }
+    return a == b // strings, bools and nil
+}
+
+func deepCopy(v any) any {
+    switch x := v.(type) {
+    case map[string]any:
+        m := make(map[string]any, len(x))
+        for k, val := range x {
+            m[k] = deepCopy(val)
+        }
+        return m
+    case []any:
+        s := make([]any, len(x))
+        for i, val := range x {
+            s[i] = deepCopy(val)
+        }
+        return s
+    }
+    return v
+}
+
+Using it in a PATCH handler:
+
+func patchBook(w http.ResponseWriter, r *http.Request) {
+    id, ok := PathParam(w, r, "id") // http-handler-helpers.go
+    if !ok {
+        return
+    }
+    current, err := loadBookJSON(id) // the stored Book, marshaled
+    ...
+    patch, err := io.ReadAll(io.LimitReader(r.Body, 1<<20))
+    ...
+    updated, err := ApplyPatch(current, patch)
+    if errors.Is(err, ErrTestFailed) {
+        http.Error(w, err.Error(), http.StatusConflict)
+        return
+    }
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+        return
+    }
+
+    var book Book
+    if err := json.Unmarshal(updated, &book); err != nil { // back into a typed struct
+        http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+        return
+    }
+    saveBook(book)
+}
+
+What's happening in that code?
+Working on map[string]any: A patch can touch any field, so ApplyPatch decodes the document into generic maps and slices instead of a struct. After patching, the handler decodes the result into Book again. That decode also checks that the patched document still has the right types.
+
+UseNumber: By default encoding/json turns every number into a float64, which silently corrupts integers above 2^53 (large IDs, for example). json.Number keeps the original text.
+
+update(): Appending to or removing from a slice can produce a new slice, so each level stores the changed child back into its parent on the way up.
+
+All or nothing: If any operation fails, ApplyPatch returns an error and none of the changes are used. The error names the failing operation, e.g. json patch: operation 3 (test /author): test failed.
+
+Important: "test" compares values the way JSON does, not the way Go does. 1 and 1.0 are equal, and the order of object keys doesn't matter.
+
+
+2. Canonical JSON
+-----------------
+json.Marshal writes struct fields in the order they are declared and map keys in sorted order. It also escapes <, > and & as \u003c, \u003e and \u0026, which is safe for HTML but surprising anywhere else. Usually none of that matters. It does matter whenever two programs must produce exactly the same bytes for the same data, for example when one side signs a payload and the other side checks the signature, or when you hash a document to detect changes.
+
+MarshalCanonical produces one fixed form: every object's keys sorted (struct fields included), no whitespace, no HTML escaping.
+
+import (
+    "bytes"
+    "encoding/json"
+)
+
+// MarshalCanonical encodes v with every object's keys in sorted order, no
+// extra whitespace, and no HTML escaping, so equal values always produce
+// byte-for-byte equal output.
+func MarshalCanonical(v any) ([]byte, error) {
+    data, err := json.Marshal(v)
+    if err != nil {
+        return nil, err
+    }
+
+    // Decoding into maps forgets the struct field order. Encoding the maps
+    // again sorts every key.
+    generic, err := decodeValue(data)
+    if err != nil {
+        return nil, err
+    }
+
+    var buf bytes.Buffer
+    enc := json.NewEncoder(&buf)
+    enc.SetEscapeHTML(false)
+    if err := enc.Encode(generic); err != nil {
+        return nil, err
+    }
+    return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), nil
+}
+
+type Event struct {
+    Type string `json:"type"`
+    ID   int    `json:"id"`
+}
+
+b, _ := json.Marshal(Event{Type: "book.created", ID: 7})    // {"type":"book.created","id":7}
+c, _ := MarshalCanonical(Event{Type: "book.created", ID: 7}) // {"id":7,"type":"book.created"}
+
+Important: MarshalCanonical reuses decodeValue from the JSON Patch recipe, which keeps numbers as json.Number. Large IDs therefore come out exactly as they went in.
+
+
+3. Decoding Untrusted JSON Safely (Size and Depth Limits)
+---------------------------------------------------------
+The bookHandler in Error-handling.go does json.NewDecoder(r.Body).Decode(&newBook) on whatever the client sends. Two kinds of malicious body can hurt it:
+
+Huge bodies: a few hundred megabytes of [1,1,1,1,...] are decoded into memory before Go notices they don't fit a Book.
+
+Deeply nested bodies: [[[[[[...]]]]]] a million levels deep is only 2MB, but decoding it recursively takes a lot of stack and CPU time.
+
+SafeDecode puts a limit on both:
+
+import (
+    "bytes"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io"
+)
+
+var (
+    ErrTooLarge = errors.New("json: body too large")
+    ErrTooDeep  = errors.New("json: nesting too deep")
+)
+
+// SafeDecode decodes one JSON value from r into v, refusing bodies larger
+// than maxBytes or nested deeper than maxDepth objects/arrays.
+func SafeDecode(r io.Reader, v any, maxDepth int, maxBytes int64) error {
+    // Read one byte more than allowed, so we can tell "exactly the limit"
+    // from "over the limit"
+    data, err := io.ReadAll(io.LimitReader(r, maxBytes+1))
+    if err != nil {
+        return err
+    }
+    if int64(len(data)) > maxBytes {
+        return fmt.Errorf("%w: more than %d bytes", ErrTooLarge, maxBytes)
+    }
+
+    if err := checkDepth(data, maxDepth); err != nil {
+        return err
+    }
+    return json.Unmarshal(data, v)
+}
+
+// checkDepth walks the tokens without building any values, so even a
+// hostile document costs very little memory to inspect.
+func checkDepth(data []byte, maxDepth int) error {
+    dec := json.NewDecoder(bytes.NewReader(data))
+    depth := 0
+    for {
+        tok, err := dec.Token()
+        if err == io.EOF {
+            return nil
+        }
+        if err != nil {
+            return err // a syntax error; Unmarshal would report the same thing
+        }
+
+        switch tok {
+        case json.Delim('{'), json.Delim('['):
+            depth++
+            if depth > maxDepth {
+                return fmt.Errorf("%w: more than %d levels", ErrTooDeep, maxDepth)
+            }
+        case json.Delim('}'), json.Delim(']'):
+            depth--
+        }
+    }
+}
+
+Using it in the Book handler:
+
+var newBook Book
+err := SafeDecode(r.Body, &newBook, 10, 1<<20) // 10 levels, 1MB
+switch {
+case errors.Is(err, ErrTooLarge):
+    http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+    return
+case err != nil:
+    http.Error(w, "Invalid JSON data", http.StatusBadRequest)
+    return
+}
+
+What's happening in that code?
+maxBytes+1: io.LimitReader stops after the limit. Asking for one extra byte is how we tell a body of exactly 1MB (fine) from a bigger one (rejected).
+
+dec.Token(): Instead of building Go values, the decoder hands us one token at a time: a delimiter like { or ], a string, a number. We only count how deep the braces and brackets go. That pass is cheap, and json.Unmarshal only runs once the document is known to be safe.
+
+Sentinel errors with %w: ErrTooLarge and ErrTooDeep are wrapped, so callers can use errors.Is to choose the status code while the message still includes the actual limit.
+
+Important: A Book has no nesting at all, so even 10 levels is generous. Pick limits based on what your real payloads look like. If you use the Router's body limit (routing.go) as well, that is fine. The smaller of the two wins.
+
+
+4. Choosing Fields per Response (MarshalFields)
+-----------------------------------------------
+The same User is often shown to different people. The account page shows the email address, and the public profile must not. The usual fix is a second struct (PublicUser) with the same fields minus one, which then has to be kept in sync forever. MarshalFields and MarshalExcept pick the fields when marshalling instead.
+
+import (
+    "bytes"
+    "encoding/json"
+    "fmt"
+    "slices"
+)
+
+// MarshalFields marshals v but keeps only the named top-level fields, using
+// their JSON names. For a slice, the filter applies to every element.
+func MarshalFields(v any, include []string) ([]byte, error) {
+    return marshalFiltered(v, func(name string) bool { return slices.Contains(include, name) })
+}
+
+// MarshalExcept marshals v without the named top-level fields.
+func MarshalExcept(v any, exclude []string) ([]byte, error) {
+    return marshalFiltered(v, func(name string) bool { return !slices.Contains(exclude, name) })
+}
+
+func marshalFiltered(v any, keep func(name string) bool) ([]byte, error) {
+    data, err := json.Marshal(v)
+    if err != nil {
+        return nil, err
+    }
+
+    data = bytes.TrimSpace(data)
+    switch {
+    case string(data) == "null":
+        return data, nil // a nil pointer or slice
+    case data[0] == '{':
+        return filterObject(data, keep)
+    case data[0] == '[':
+        var items []json.RawMessage
+        if err := json.Unmarshal(data, &items); err != nil {
+            return nil, err
+        }
+        for i, item := range items {
+            if items[i], err = filterObject(item, keep); err != nil {
+                return nil, err
+            }
+        }
+        return json.Marshal(items)
+    default:
+        return nil, fmt.Errorf("marshal fields: %T is not an object or a list of objects", v)
+    }
+}
+
+// filterObject copies the kept members of a JSON object. It reads the
+// members in order with a Decoder, so the output keeps the struct's field
+// order instead of sorting the keys like a map would.
+func filterObject(data []byte, keep func(name string) bool) ([]byte, error) {
+    dec := json.NewDecoder(bytes.NewReader(data))
+    if tok, err := dec.Token(); err != nil || tok != json.Delim('{') {
+        return nil, fmt.Errorf("marshal fields: expected an object, got %s", data)
+    }
+
+    var buf bytes.Buffer
+    buf.WriteByte('{')
+    for dec.More() {
+        tok, err := dec.Token()
+        if err != nil {
+            return nil, err
+        }
+        key := tok.(string) // object keys are always strings
+        var value json.RawMessage
+        if err := dec.Decode(&value); err != nil {
+            return nil, err
+        }
+        if !keep(key) {
+            continue
+        }
+        if buf.Len() > 1 {
+            buf.WriteByte(',')
+        }
+        name, _ := json.Marshal(key)
+        buf.Write(name)
+        buf.WriteByte(':')
+        buf.Write(value)
+    }
+    buf.WriteByte('}')
+    return buf.Bytes(), nil
+}
+
+Using it:
+
+type User struct {
+    ID    int    `json:"id"`
+    Name  string `json:"name"`
+    Email string `json:"email"`
+}
+
+func publicProfile(w http.ResponseWriter, r *http.Request) {
+    user := ... // load the user
+    data, err := MarshalExcept(user, []string{"email"})
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusInternalServerError)
+        return
+    }
+    w.Header().Set("Content-Type", "application/json")
+    w.Write(data) // {"id":1,"name":"Ann"}
+}
+
+It also works for sparse fieldsets, where the client picks the fields: GET /users?fields=id,name.
+
+fields := strings.Split(r.URL.Query().Get("fields"), ",")
+data, err := MarshalFields(users, fields) // [{"id":1,"name":"Ann"},{"id":2,"name":"Bob"}]
+
+What's happening in that code?
+Marshal first, filter second: v goes through json.Marshal as usual, so struct tags, omitempty, and custom MarshalJSON methods all still apply. The filter only ever sees JSON names, which is exactly what the caller passes in.
+
+Keeping the order: Decoding into a map[string]json.RawMessage would be simpler, but maps have no order and would sort the keys. The Decoder reads the members in order, and json.RawMessage keeps each value's bytes untouched, so nested objects come through exactly as marshalled.
+
+Lists: A slice of structs is filtered element by element, so the same call works for GET /users/1 and GET /users.
+
+Important: Only top-level fields are filtered. Hiding author.email inside a Book needs the filter on the nested value too. For hiding data, prefer MarshalExcept with a fixed list in code over letting the client choose. A client can only ask MarshalFields for fields that exist on the struct, so anything secret (like a password hash) should carry json:"-" regardless.
+
+
+5. Absent vs null in Partial Updates (Optional)
+-----------------------------------------------
+A PATCH request sends only the fields to change. For a field like bio there are three different requests:
+
+{"name": "Ann"}              bio is absent: leave it alone
+{"name": "Ann", "bio": null} bio is null: clear it
+{"bio": "Gopher"}            bio is set: change it
+
+Decoded into a plain struct, the first two look the same, because both leave Bio as "". Even a *string can only tell "set" from "not set", not absent from null. Optional keeps all three apart:
+
+import (
+    "bytes"
+    "encoding/json"
+)
+
+// Optional tells apart the three states a JSON field can be in: absent
+// (Present is false), null (Present and Null), or set to Value.
+type Optional[T any] struct {
+    Present bool
+    Null    bool
+    Value   T
+}
+
+// UnmarshalJSON is only called when the key is in the input, which is what
+// makes Present work. For a missing key the field keeps its zero value.
+func (o *Optional[T]) UnmarshalJSON(data []byte) error {
+    o.Present = true
+    if bytes.Equal(bytes.TrimSpace(data), []byte("null")) {
+        o.Null = true
+        var zero T
+        o.Value = zero
+        return nil
+    }
+    o.Null = false
+    return json.Unmarshal(data, &o.Value)
+}
+
+// MarshalJSON writes null for absent and null fields. Tag the field with
+// omitzero to leave absent ones out entirely.
+func (o Optional[T]) MarshalJSON() ([]byte, error) {
+    if !o.Present || o.Null {
+        return []byte("null"), nil
+    }
+    return json.Marshal(o.Value)
+}
+
+// Set reports whether the field was given a real (non-null) value.
+func (o Optional[T]) Set() bool {
+    return o.Present && !o.Null
+}
+
+Using it in a PATCH handler:
+
+type userPatch struct {
+    Name  Optional[string] `json:"name"`
+    Email Optional[string] `json:"email"`
+    Bio   Optional[string] `json:"bio"`
+}
+
+func patchUser(w http.ResponseWriter, r *http.Request) {
+    id, ok := PathParam(w, r, "id") // http-handler-helpers.go
+    if !ok {
+        return
+    }
+    var p userPatch
+    if !DecodeJSON(w, r, &p) { // Error-handling.go
+        return
+    }
+
+    var sets []string
+    var args []any
+    if p.Name.Null || p.Email.Null {
+        WriteError(w, &AppError{Code: CodeValidation, Message: "name and email can't be null"})
+        return
+    }
+    if p.Name.Set() {
+        sets, args = append(sets, "name = ?"), append(args, p.Name.Value)
+    }
+    if p.Email.Set() {
+        sets, args = append(sets, "email = ?"), append(args, p.Email.Value)
+    }
+    if p.Bio.Present {
+        // null clears the bio: sql.Null with Valid=false is written as NULL
+        sets, args = append(sets, "bio = ?"), append(args, sql.Null[string]{V: p.Bio.Value, Valid: !p.Bio.Null})
+    }
+    if len(sets) == 0 {
+        w.WriteHeader(http.StatusNoContent) // nothing to change
+        return
+    }
+
+    args = append(args, id)
+    _, err := db.ExecContext(r.Context(), "UPDATE users SET "+strings.Join(sets, ", ")+" WHERE id = ?", args...)
+    if err != nil {
+        WriteError(w, err)
+        return
+    }
+    w.WriteHeader(http.StatusNoContent)
+}
+
+What's happening in that code?
+Only called when the key is there: encoding/json calls UnmarshalJSON for every key it finds, including "bio": null, and never for keys that aren't in the body. So Present is true exactly when the client sent the field.
+
+null is handled by hand: For a null value json.Unmarshal of a string would do nothing at all and report no error, so we check for the literal null first and set Null instead.
+
+The SET list from fixed strings: Only the column names written in the handler end up in the SQL, and every value goes through a ? placeholder, so building the query this way is still safe from SQL injection.
+
+Marshalling back: An Optional that is absent or null writes null. With the omitzero tag option (Go 1.24 and later, `json:"bio,omitzero"`), an absent field is left out of the output completely, because an Optional that was never set is its zero value.
+
+Important: A type mismatch inside an Optional ("age": "ten") is still an error, but its message doesn't include the field name, because the inner json.Unmarshal doesn't know it. Validate the combinations that make no sense (a required field sent as null) before touching the database, as the handler does for name and email.
+
+
+6. Encoding Many Small Values into One Array (BatchEncoder)
+-----------------------------------------------------------
+A handler that returns 10,000 rows usually builds a slice and hands it to json.NewEncoder(w).Encode. That's fine until the rows come from somewhere one at a time (a cursor, a channel, a filter that skips some), and the code ends up calling json.Marshal for each row and gluing the pieces together with commas. Every json.Marshal call allocates a new byte slice for its result, copies it into the output, and throws it away.
+
+BatchEncoder keeps one buffer and one json.Encoder for the whole array. Each Append encodes straight into the buffer, and the buffers themselves are reused across requests through a sync.Pool:
+
+import (
+    "bytes"
+    "encoding/json"
+    "io"
+    "sync"
+)
+
+var batchBuffers = sync.Pool{
+    New: func() any { return new(bytes.Buffer) },
+}
+
+// Buffers larger than this go to the garbage collector instead of back to
+// the pool, so one huge export doesn't keep its memory forever.
+const maxPooledBuffer = 4 << 20
+
+// BatchEncoder collects many values into one JSON array, encoding them all
+// into the same buffer.
+type BatchEncoder struct {
+    buf *bytes.Buffer
+    enc *json.Encoder
+    n   int
+}
+
+func NewBatchEncoder() *BatchEncoder {
+    buf := batchBuffers.Get().(*bytes.Buffer)
+    buf.Reset()
+    buf.WriteByte('[')
+    return &BatchEncoder{buf: buf, enc: json.NewEncoder(buf)}
+}
+
+// Append adds v to the array. If v can't be encoded, the array is left as
+// it was and the error is returned.
+func (b *BatchEncoder) Append(v any) error {
+    mark := b.buf.Len()
+    if b.n > 0 {
+        b.buf.WriteByte(',')
+    }
+    if err := b.enc.Encode(v); err != nil {
+        b.buf.Truncate(mark) // drop the comma and anything half-written
+        return err
+    }
+    b.buf.Truncate(b.buf.Len() - 1) // Encode ends every value with a newline
+    b.n++
+    return nil
+}
+
+// Len returns the number of values appended so far.
+func (b *BatchEncoder) Len() int {
+    return b.n
+}
+
+// Flush closes the array and writes it to w. The BatchEncoder can't be used
+// afterwards; its buffer goes back to the pool.
+func (b *BatchEncoder) Flush(w io.Writer) error {
+    b.buf.WriteByte(']')
+    _, err := w.Write(b.buf.Bytes())
+
+    if b.buf.Cap() <= maxPooledBuffer {
+        batchBuffers.Put(b.buf)
+    }
+    b.buf, b.enc = nil, nil
+    return err
+}
+
+Using it with StreamCursor from database-recipes.go, which hands over one batch of rows at a time:
+
+func exportUsers(w http.ResponseWriter, r *http.Request) {
+    tx, err := db.BeginTx(r.Context(), &sql.TxOptions{ReadOnly: true})
+    if err != nil {
+        WriteError(w, err)
+        return
+    }
+    defer tx.Rollback()
+
+    batch := NewBatchEncoder()
+    err = StreamCursor(r.Context(), tx, "users_export", "SELECT id, name, email FROM users WHERE active", 1000,
+        func(ctx context.Context, users []User) error {
+            for _, u := range users {
+                if err := batch.Append(u); err != nil {
+                    return err
+                }
+            }
+            return nil
+        })
+    if err != nil {
+        WriteError(w, err) // nothing has been written yet, so a clean error response is still possible
+        return
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    batch.Flush(w)
+}
+
+Measuring the difference: These benchmarks encode the same 10,000 rows both ways:
+
+import (
+    "bytes"
+    "encoding/json"
+    "io"
+    "testing"
+    "time"
+)
+
+type benchRow struct {
+    ID      int       `json:"id"`
+    Name    string    `json:"name"`
+    Email   string    `json:"email"`
+    Created time.Time `json:"created"`
+}
+
+var benchRows = func() []benchRow {
+    rows := make([]benchRow, 10_000)
+    for i := range rows {
+        rows[i] = benchRow{ID: i, Name: "Ada Lovelace", Email: "ada@example.com", Created: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)}
+    }
+    return rows
+}()
+
+func BenchmarkMarshalEach(b *testing.B) {
+    b.ReportAllocs()
+    for range b.N {
+        var out bytes.Buffer
+        out.WriteByte('[')
+        for i, r := range benchRows {
+            if i > 0 {
+                out.WriteByte(',')
+            }
+            data, err := json.Marshal(r)
+            if err != nil {
+                b.Fatal(err)
+            }
+            out.Write(data)
+        }
+        out.WriteByte(']')
+        io.Discard.Write(out.Bytes())
+    }
+}
+
+func BenchmarkBatchEncoder(b *testing.B) {
+    b.ReportAllocs()
+    for range b.N {
+        enc := NewBatchEncoder()
+        for _, r := range benchRows {
+            if err := enc.Append(r); err != nil {
+                b.Fatal(err)
+            }
+        }
+        enc.Flush(io.Discard)
+    }
+}
+
+go test -bench . -benchmem gives numbers like these (they vary with the machine and the Go version):
+
+BenchmarkMarshalEach     7381876 ns/op   4337327 B/op   30018 allocs/op
+BenchmarkBatchEncoder    5832719 ns/op   1280320 B/op   20004 allocs/op
+
+A third of the allocations and more than two thirds of the memory are gone, and it's about 20% faster. The 2 allocations per row that are left don't come from the encoding: one is the row being boxed into an interface for Append(v any), the other is time.Time formatting itself.
+
+What's happening in that code?
+Trimming the newline: json.Encoder writes a newline after every value, which is what you want for a stream of JSON lines but not inside an array. Append cuts it off again right after Encode. Truncate only moves the end of the buffer, so this costs nothing.
+
+Rolling back a failed value: If v can't be encoded (a channel, a NaN float), Encode may already have written part of it. Append remembers the buffer length before the comma and truncates back to it, so the array stays valid and the caller decides whether to skip the value or give up.
+
+sync.Pool: Get returns a buffer some earlier request was done with, capacity and all, so after the first few requests the buffer doesn't have to grow anymore. Reset empties it but keeps the memory. Buffers above 4MB aren't put back: the pool would hold one export's peak memory for as long as it keeps being reused.
+
+Flush ends the batch: After Flush the buffer belongs to the pool again, and another request may already be writing into it, so the BatchEncoder must not be used anymore. Setting b.buf to nil makes any later Append panic right away, instead of corrupting someone else's response.
+
+Important: BatchEncoder builds the whole array in memory before writing it, which is the right trade for responses up to a few megabytes: if something fails halfway, you can still send a clean error. For really large results, write each value straight to the response as it comes, for example as JSON Lines through StreamExport in streaming-responses.go, so memory stays flat no matter how many rows there are. A BatchEncoder is not safe for use by several goroutines at once.
+
+
+7. Stable Output for Snapshot Tests (MarshalSortedMaps)
+-------------------------------------------------------
+A snapshot test saves a handler's response to a file once (testdata/get_user.json) and compares every later run against it. That only works if the same data always produces the same bytes, and API responses often carry a map: user attributes, feature flags, counts per status. Go randomises map iteration order on purpose, so it's tempting to think the snapshot will change on every run.
+
+It won't, because encoding/json already sorts map keys, at every depth. MarshalCanonical from section 2 goes one step further and sorts struct fields too, which is what a signature needs but not what a snapshot wants: the file should look like the real response, fields in the order the struct declares them. So MarshalSortedMaps leaves the order to encoding/json and only fixes the formatting, one key per line, so a change shows up as a readable diff:
+
+import (
+    "bytes"
+    "encoding/json"
+)
+
+// MarshalSortedMaps encodes v for snapshot files: map keys sorted at every
+// depth, struct fields in declared order, two-space indentation and no HTML
+// escaping. Values that marshal themselves (json.RawMessage, MarshalJSON)
+// are re-indented but keep their own key order.
+func MarshalSortedMaps(v any) ([]byte, error) {
+    var buf bytes.Buffer
+    enc := json.NewEncoder(&buf)
+    enc.SetEscapeHTML(false)
+    enc.SetIndent("", "  ")
+    if err := enc.Encode(v); err != nil {
+        return nil, err
+    }
+    return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), nil
+}
+
+Using it in a test:
+
+func TestGetUserSnapshot(t *testing.T) {
+    rec := httptest.NewRecorder()
+    getUser(rec, httptest.NewRequest("GET", "/users/7", nil))
+
+    var body any
+    if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+        t.Fatal(err)
+    }
+    got, err := MarshalSortedMaps(body)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    path := filepath.Join("testdata", "get_user.json")
+    if os.Getenv("UPDATE_SNAPSHOTS") != "" {
+        os.WriteFile(path, got, 0o644)
+    }
+    want, err := os.ReadFile(path)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if !bytes.Equal(got, want) {
+        t.Errorf("response changed:\n%s", got)
+    }
+}
+
+What's happening in that code?
+Sorted maps, declared structs: encoding/json sorts the keys of every map it encodes, whether it's a map[string]any at the top or a map[string]int three structs deep. Struct fields keep their declared order. The output therefore depends only on the data, never on map iteration order.
+
+Keys that aren't strings: A map[int]T is sorted by the text of the key, so "10" comes before "9". That looks odd but it never changes between runs, which is all a snapshot needs.
+
+Values that encode themselves: A json.RawMessage or a type with its own MarshalJSON is copied as it is, only re-indented. If a MarshalJSON method ranges over a map and writes the keys itself, its output changes from run to run, and MarshalSortedMaps can't fix that. Such a method should call json.Marshal on the map instead.
+
+No HTML escaping: A snapshot containing "a<b" should say a<b, not a\u003cb, so a reviewer can read the diff.
+
+The test round trip: The test decodes the response into any and encodes it again, so the snapshot doesn't depend on the handler's own formatting. Decoding into any turns every object into a map, so in this test the keys come out sorted even for structs. To keep the declared order, decode into the response type instead of any. Setting UPDATE_SNAPSHOTS=1 rewrites the file after an intended change.
+
+Important: A snapshot is only stable if the data is. Timestamps, generated IDs and random tokens change on every run whatever the encoder does. Replace them with fixed values in the test (a fake clock, a fixed ID sequence) before comparing, or the test fails every time.
+
+
+8. Times in UTC Out, Local Times In (MarshalUTC and UnmarshalInZone)
+--------------------------------------------------------------------
+json.Marshal writes a time.Time with the offset it happens to carry: "2024-07-01T12:00:00+02:00" from a server in Berlin, "2024-07-01T10:00:00Z" from one in UTC. Both mean the same instant, but clients that compare strings, cache by them, or just show them see two different things. Where the offset comes from is easy to miss: the MySQL DSN in connecting-to-databases.go has loc=Local, so every time scanned from the database carries the server's zone, and a Movie or Book struct that gains a ReleasedAt field sends it to clients as is.
+
+The other direction has the opposite problem. Clients, spreadsheets and older systems send "2024-07-01 10:00:00" with no zone at all, and time.Time's UnmarshalJSON refuses anything that isn't RFC 3339, so the request fails.
+
+MarshalUTC fixes the output, UnmarshalInZone the input:
+
+import (
+    "encoding/json"
+    "reflect"
+    "strings"
+    "time"
+)
+
+var timeType = reflect.TypeFor[time.Time]()
+
+// MarshalUTC encodes v like json.Marshal, with every time.Time in it
+// converted to UTC first, so responses never depend on the server's zone.
+// v itself is not changed.
+func MarshalUTC(v any) ([]byte, error) {
+    u := toUTC(reflect.ValueOf(v))
+    if !u.IsValid() {
+        return json.Marshal(nil)
+    }
+    return json.Marshal(u.Interface())
+}
+
+// toUTC returns a copy of v with every time.Time in UTC. Unexported struct
+// fields are copied as they are; encoding/json doesn't see them anyway,
+// except for the exported fields of an embedded struct, which it promotes.
+func toUTC(v reflect.Value) reflect.Value {
+    if !v.IsValid() {
+        return v
+    }
+    if v.Type() == timeType {
+        return reflect.ValueOf(v.Interface().(time.Time).UTC())
+    }
+
+    switch v.Kind() {
+    case reflect.Pointer, reflect.Interface:
+        if v.IsNil() {
+            return v
+        }
+        inner := toUTC(v.Elem())
+        if v.Kind() == reflect.Interface {
+            out := reflect.New(v.Type()).Elem()
+            out.Set(inner)
+            return out
+        }
+        out := reflect.New(v.Type().Elem())
+        out.Elem().Set(inner)
+        return out
+
+    case reflect.Struct:
+        out := reflect.New(v.Type()).Elem()
+        out.Set(v)
+        fieldsToUTC(out)
+        return out
+
+    case reflect.Slice, reflect.Array:
+        if !mayHoldTime(v.Type().Elem()) || (v.Kind() == reflect.Slice && v.IsNil()) {
+            return v // e.g. a []byte, nothing to convert
+        }
+        var out reflect.Value
+        if v.Kind() == reflect.Slice {
+            out = reflect.MakeSlice(v.Type(), v.Len(), v.Len())
+        } else {
+            out = reflect.New(v.Type()).Elem()
+        }
+        for i := range v.Len() {
+            out.Index(i).Set(toUTC(v.Index(i)))
+        }
+        return out
+
+    case reflect.Map:
+        if !mayHoldTime(v.Type().Elem()) || v.IsNil() {
+            return v
+        }
+        out := reflect.MakeMapWithSize(v.Type(), v.Len())
+        for iter := v.MapRange(); iter.Next(); {
+            out.SetMapIndex(iter.Key(), toUTC(iter.Value()))
+        }
+        return out
+    }
+    return v
+}
+
+// fieldsToUTC converts the fields of the addressable struct s in place.
+func fieldsToUTC(s reflect.Value) {
+    for i := range s.NumField() {
+        f := s.Type().Field(i)
+        switch {
+        case f.IsExported():
+            s.Field(i).Set(toUTC(s.Field(i)))
+        case f.Anonymous && f.Type.Kind() == reflect.Struct:
+            // An unexported embedded type, but encoding/json still writes its
+            // exported fields, and reflect lets us set those
+            fieldsToUTC(s.Field(i))
+        }
+    }
+}
+
+func mayHoldTime(t reflect.Type) bool {
+    switch t.Kind() {
+    case reflect.Struct, reflect.Pointer, reflect.Interface, reflect.Slice, reflect.Array, reflect.Map:
+        return true
+    }
+    return false
+}
+
+// UnmarshalInZone decodes data into v like json.Unmarshal, but also accepts
+// time.Time values without a zone ("2024-05-01T10:00:00", "2024-05-01
+// 10:00:00", "2024-05-01") and reads them as times in loc. Times with a
+// zone or offset are decoded as they are.
+func UnmarshalInZone(data []byte, v any, loc *time.Location) error {
+    tree, err := decodeValue(data)
+    if err != nil {
+        return err
+    }
+    tree, changed := zoneNaive(tree, reflect.TypeOf(v), loc)
+    if !changed {
+        return json.Unmarshal(data, v) // nothing to fix
+    }
+    fixed, err := json.Marshal(tree)
+    if err != nil {
+        return err
+    }
+    return json.Unmarshal(fixed, v)
+}
+
+// zoneNaive walks the decoded JSON next to the Go type it will be decoded
+// into, rewrites naive times meant for a time.Time as RFC 3339 in loc, and
+// reports whether it changed anything. Maps and slices are changed in
+// place; a node that is itself a naive time comes back as a new string.
+func zoneNaive(node any, t reflect.Type, loc *time.Location) (any, bool) {
+    for t != nil && t.Kind() == reflect.Pointer {
+        t = t.Elem()
+    }
+    if t == nil {
+        return node, false
+    }
+
+    changed := false
+    switch n := node.(type) {
+    case string:
+        if t == timeType {
+            if at, ok := parseNaive(n, loc); ok {
+                return at, true
+            }
+        }
+    case []any:
+        if t.Kind() == reflect.Slice || t.Kind() == reflect.Array {
+            for i, item := range n {
+                if fixed, ok := zoneNaive(item, t.Elem(), loc); ok {
+                    n[i], changed = fixed, true
+                }
+            }
+        }
+    case map[string]any:
+        for key, item := range n {
+            var ft reflect.Type
+            switch t.Kind() {
+            case reflect.Map:
+                ft = t.Elem()
+            case reflect.Struct:
+                ft = jsonField(t, key)
+            }
+            if fixed, ok := zoneNaive(item, ft, loc); ok {
+                n[key], changed = fixed, true
+            }
+        }
+    }
+    return node, changed
+}
+
+// parseNaive parses s as a time without a zone, in loc, and formats it as
+// RFC 3339. Strings that have a zone, or aren't times at all, give false.
+func parseNaive(s string, loc *time.Location) (string, bool) {
+    for _, layout := range []string{"2006-01-02T15:04:05", "2006-01-02 15:04:05", "2006-01-02"} {
+        if at, err := time.ParseInLocation(layout, s, loc); err == nil {
+            return at.Format(time.RFC3339Nano), true
+        }
+    }
+    return "", false
+}
+
+// jsonField returns the type of the field of struct t that encoding/json
+// would decode key into, or nil if there is none.
+func jsonField(t reflect.Type, key string) reflect.Type {
+    var folded reflect.Type
+    for i := range t.NumField() {
+        f := t.Field(i)
+        name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+        if name == "-" || (!f.IsExported() && !f.Anonymous) {
+            continue
+        }
+        if f.Anonymous && name == "" {
+            // Fields of an embedded struct are promoted
+            et := f.Type
+            if et.Kind() == reflect.Pointer {
+                et = et.Elem()
+            }
+            if et.Kind() == reflect.Struct {
+                if ft := jsonField(et, key); ft != nil {
+                    return ft
+                }
+                continue
+            }
+        }
+        if name == "" {
+            name = f.Name
+        }
+        if name == key {
+            return f.Type
+        }
+        if folded == nil && strings.EqualFold(name, key) {
+            folded = f.Type // encoding/json falls back to a case-insensitive match
+        }
+    }
+    return folded
+}
+
+Using it:
+
+type Movie struct {
+    Title      string    `json:"title"`
+    ReleasedAt time.Time `json:"released_at"`
+}
+
+berlin, err := time.LoadLocation("Europe/Berlin")
+if err != nil {
+    log.Fatal(err) // no time zone database, see below
+}
+
+var m Movie
+err = UnmarshalInZone([]byte(`{"title":"Inception","released_at":"2010-07-22 20:00:00"}`), &m, berlin)
+// m.ReleasedAt is 20:00 at +02:00, summer time in Berlin
+
+data, _ := MarshalUTC(m)
+// {"title":"Inception","released_at":"2010-07-22T18:00:00Z"}
+
+What's happening in that code?
+A converted copy: toUTC walks v with reflect and builds a copy in which every time.Time is replaced by its UTC version, through pointers, slices, maps and interfaces. Struct values are copied whole first, so unexported fields come along unchanged. The caller's value is never modified, which matters when it's shared, like a cached response.
+
+Embedded structs: encoding/json writes the exported fields of an embedded struct as if they belonged to the outer one, even when the embedded type is unexported, like a timestamps struct with CreatedAt and UpdatedAt embedded in several models. fieldsToUTC follows such fields too. reflect won't let us replace the unexported field as a whole, but it does let us set its exported fields inside our copy, the same way encoding/json fills them when decoding.
+
+Skipping what can't hold a time: A []byte or a []int can't contain a time.Time, so mayHoldTime lets toUTC return those as they are instead of copying them element by element.
+
+Following the target type: UnmarshalInZone decodes the JSON generically first (decodeValue from the JSON Patch recipe, so numbers stay exact) and walks it alongside the type of v. Only a string headed for a time.Time, or a *time.Time, is considered: a "note" field that happens to contain "2024-07-01 10:00:00" stays exactly what the client sent. jsonField finds the struct field for a key the way encoding/json does: the json tag, then the field name, promoted fields of embedded structs, and a case-insensitive match last.
+
+Naive means no zone: parseNaive tries three layouts without a zone, with a T, with a space, and a bare date for midnight. A string with a Z or an offset doesn't match them, so it's decoded normally with its own offset. time.ParseInLocation picks the right offset for the date, +01:00 in January and +02:00 in July for Berlin.
+
+Only rewritten when needed: If no naive time was found, UnmarshalInZone decodes the original data directly, so its error messages point at the bytes the client actually sent.
+
+Important: time.LoadLocation needs the time zone database, which minimal container images (scratch, distroless) don't always have. Import _ "time/tzdata" in main to build a copy into the binary. A naive time that falls into the hour skipped by daylight saving time (02:30 on the last Sunday of March in Berlin) doesn't exist; Go moves it forward to 03:30. A type with its own MarshalJSON is walked like any other: its exported time.Time fields are converted in the copy before the method runs, so the method encodes UTC times. Only times the method takes from unexported fields, or works out itself, keep their zone. An unexported embedded struct behind a pointer (*timestamps) is left alone, because converting it in place would change the caller's value.
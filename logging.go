@@ -0,0 +1,123 @@
+Logging in Go
+=============
+
+The log package from the standard library prints a line with a timestamp:
+
+log.Println("server started") // 2026/10/14 09:30:00 server started
+
+Since Go 1.21 there is also log/slog ("structured logging"). Instead of building one long string, you log a message plus key/value pairs, which log tools can search and filter:
+
+slog.Info("user created", "id", 42, "email", "ada@example.com")
+// 2026/10/14 09:30:00 INFO user created id=42 email=ada@example.com
+
+slog.SetDefault(slog.New(slog.NewJSONHandler(os.Stdout, nil)))
+slog.Info("user created", "id", 42)
+// {"time":"2026-10-14T09:30:00Z","level":"INFO","msg":"user created","id":42}
+
+
+1. One Logger Interface for Every Helper
+----------------------------------------
+The helpers in these notes all need to log something: the request logger, the database wrapper, the SSE hub when it drops a slow client, the BufferedWriter when a batch fails. If each one called log.Printf or slog directly, you couldn't send their output somewhere else, or silence it in tests.
+
+So they all depend on one small interface instead. It lives in its own tiny package, applog, so it doesn't clash with the Logger middleware in http-middleware.go.
+
+import "log/slog"
+
+// Logger is the only logging API the helpers use. kv holds alternating
+// keys and values: l.Info("query done", "rows", 12, "took", d).
+type Logger interface {
+    Debug(msg string, kv ...any)
+    Info(msg string, kv ...any)
+    Warn(msg string, kv ...any)
+    Error(msg string, kv ...any)
+}
+
+// *slog.Logger already has exactly these four methods.
+var _ Logger = (*slog.Logger)(nil)
+
+// Default logs through slog.Default(), so it follows slog.SetDefault.
+func Default() Logger {
+    return defaultLogger{}
+}
+
+type defaultLogger struct{}
+
+func (defaultLogger) Debug(msg string, kv ...any) { slog.Default().Debug(msg, kv...) }
+func (defaultLogger) Info(msg string, kv ...any)  { slog.Default().Info(msg, kv...) }
+func (defaultLogger) Warn(msg string, kv ...any)  { slog.Default().Warn(msg, kv...) }
+func (defaultLogger) Error(msg string, kv ...any) { slog.Default().Error(msg, kv...) }
+
+// Nop discards everything, which is handy in tests.
+func Nop() Logger {
+    return slog.New(slog.DiscardHandler)
+}
+
+Every helper that logs takes it through a WithLogger option (or LoggerWith for the middleware). WithLogger and its Option type are declared once, here, and every helper's options use that same type. config holds every setting an option can change. Each helper reads the fields it needs, and the options for the other fields are declared next to the helpers that use them:
+
+import (
+    "time"
+
+    "myapp/applog"
+    "myapp/clock"
+)
+
+// Option changes one setting of a helper.
+type Option func(*config)
+
+type config struct {
+    logger applog.Logger
+    clock  clock.Clock
+
+    // database-recipes.go
+    checkArgs bool
+    dialect   Dialect
+    converter ValueConverter
+
+    // worker-pools.go
+    deadLetter func(TaskInfo, error)
+    attempts   int
+    retryDelay time.Duration
+}
+
+func WithLogger(l applog.Logger) Option {
+    return func(c *config) { c.logger = l }
+}
+
+// WithClock replaces the real clock, so tests can skip the waiting (see
+// testing-helpers.go).
+func WithClock(c clock.Clock) Option {
+    return func(cfg *config) { cfg.clock = c }
+}
+
+func newConfig(opts []Option) config {
+    c := config{logger: applog.Default(), clock: clock.Real()}
+    for _, opt := range opts {
+        opt(&c)
+    }
+    return c
+}
+
+When you pass nothing, a helper uses applog.Default():
+
+l := slog.New(slog.NewJSONHandler(os.Stdout, nil)) // *slog.Logger is an applog.Logger
+
+db := Wrap(sqlDB, WithLogger(l))                              // database-recipes.go
+writer := NewBufferedWriter(sqlDB, "events", cols, 500, time.Second, WithLogger(l))
+hub := NewHub(32, WithLogger(l))                              // streaming-responses.go
+pool := NewWorkerPool(20, 100, WithLogger(l))                 // worker-pools.go
+watcher, err := NewConfigWatcher("config.json", WithLogger(l)) // configuration.go
+handler := LoggerWith(l)(router)                              // http-middleware.go
+
+// In tests:
+hub := NewHub(32, WithLogger(applog.Nop()))
+
+What's happening in that code?
+Four methods, nothing else: The interface is kept as small as possible so it is easy to adapt any logging library to it. *slog.Logger already matches exactly, which the var _ Logger = (*slog.Logger)(nil) line checks at compile time.
+
+Default follows slog.SetDefault: defaultLogger looks up slog.Default() on every call rather than once at startup. If main() installs a JSON handler after the helpers were created, their logs still switch to JSON.
+
+Options: WithLogger is a "functional option", a function that changes one setting. Constructors take any number of them (opts ...Option), so new settings can be added later without breaking existing calls.
+
+One Option type: Because every helper takes the same Option, WithLogger and WithClock are written once and work everywhere. newConfig fills in the defaults first and then applies each option, so a helper called without options still gets a working logger and the real clock.
+
+Important: Always log key/value pairs, never fmt.Sprintf'd messages. "query failed" with sql=... and err=... can be searched and grouped. "query SELECT ... failed: ..." can only be read by a human.
@@ -0,0 +1,258 @@
+Metrics
+=======
+
+Logs tell you what happened to one request. Metrics tell you how the whole program is doing: how many requests per second, how many errors, how long they take, how many connections are open. They are plain numbers that a time series database (Graphite, InfluxDB, Prometheus, Datadog) stores over time and draws as graphs.
+
+This file builds a small registry for those numbers and a flusher that pushes them out on a fixed interval. Only the standard library is used. In a bigger system you would probably use the client library of your monitoring system, which works the same way.
+
+
+1. A Metrics Registry
+---------------------
+There are three kinds of metric, and almost everything fits one of them:
+
+Counter: Only goes up. Requests served, errors, bytes written.
+Gauge: A current value that goes up and down. Open connections, queue length, SSE clients (Hub.ClientCount in streaming-responses.go).
+Histogram: How values are spread out. Request durations, response sizes.
+
+import (
+    "math"
+    "slices"
+    "sync"
+    "sync/atomic"
+)
+
+// Metrics holds every named counter, gauge and histogram of the program.
+type Metrics struct {
+    mu         sync.Mutex
+    counters   map[string]*Counter
+    gauges     map[string]*Gauge
+    histograms map[string]*Histogram
+}
+
+func NewMetrics() *Metrics {
+    return &Metrics{
+        counters:   make(map[string]*Counter),
+        gauges:     make(map[string]*Gauge),
+        histograms: make(map[string]*Histogram),
+    }
+}
+
+// Counter returns the counter called name, creating it on first use.
+func (m *Metrics) Counter(name string) *Counter {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    c, ok := m.counters[name]
+    if !ok {
+        c = &Counter{}
+        m.counters[name] = c
+    }
+    return c
+}
+
+// Gauge returns the gauge called name, creating it on first use.
+func (m *Metrics) Gauge(name string) *Gauge {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    g, ok := m.gauges[name]
+    if !ok {
+        g = &Gauge{}
+        m.gauges[name] = g
+    }
+    return g
+}
+
+// Histogram returns the histogram called name, creating it with the given
+// bucket upper bounds on first use. Later calls ignore buckets.
+func (m *Metrics) Histogram(name string, buckets []float64) *Histogram {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    h, ok := m.histograms[name]
+    if !ok {
+        bounds := slices.Clone(buckets)
+        slices.Sort(bounds)
+        h = &Histogram{bounds: bounds, counts: make([]int64, len(bounds)+1)}
+        m.histograms[name] = h
+    }
+    return h
+}
+
+// Counter only goes up: requests served, errors, bytes sent.
+type Counter struct {
+    n atomic.Int64
+}
+
+func (c *Counter) Inc()        { c.n.Add(1) }
+func (c *Counter) Add(n int64) { c.n.Add(n) }
+
+// Gauge is a value that goes up and down: open connections, queue length.
+type Gauge struct {
+    bits atomic.Uint64 // a float64, stored as its bits so it can be atomic
+}
+
+func (g *Gauge) Set(v float64) { g.bits.Store(math.Float64bits(v)) }
+func (g *Gauge) Value() float64 { return math.Float64frombits(g.bits.Load()) }
+
+// Histogram counts observations per bucket, e.g. request durations.
+type Histogram struct {
+    mu     sync.Mutex
+    bounds []float64 // upper bounds, sorted
+    counts []int64   // one per bound, plus one for "above the last bound"
+    sum    float64
+    count  int64
+}
+
+func (h *Histogram) Observe(v float64) {
+    i, _ := slices.BinarySearch(h.bounds, v) // first bound >= v
+    h.mu.Lock()
+    h.counts[i]++
+    h.sum += v
+    h.count++
+    h.mu.Unlock()
+}
+
+Using it:
+
+var (
+    metrics         = NewMetrics()
+    requests        = metrics.Counter("http.requests")
+    requestDuration = metrics.Histogram("http.duration_seconds", []float64{0.01, 0.05, 0.1, 0.5, 1})
+)
+
+func getUsers(w http.ResponseWriter, r *http.Request) {
+    start := time.Now()
+    defer func() {
+        requests.Inc()
+        requestDuration.Observe(time.Since(start).Seconds())
+    }()
+    ...
+}
+
+metrics.Gauge("db.open_connections").Set(float64(db.Stats().OpenConnections))
+
+What's happening in that code?
+Get or create: Counter, Gauge and Histogram look the metric up by name and create it the first time. Handlers don't need any setup code, and asking for the same name twice returns the same metric.
+
+Atomics for the hot path: Inc is called on every request, so Counter uses an atomic.Int64 and never takes a lock. There is no atomic float64, so Gauge stores the float's 64 bits in an atomic.Uint64 and converts them back with math.Float64frombits.
+
+Buckets: A histogram doesn't keep every value, only how many fell into each bucket. slices.BinarySearch finds the first upper bound that is at least v, and the extra last bucket catches everything above the largest bound.
+
+Important: The registry's mutex is only taken to look a metric up. In a handler that runs thousands of times a second, look it up once and keep the pointer, like the package-level variables above, instead of calling metrics.Counter on every request.
+
+
+2. Pushing Metrics on a Schedule (StartFlusher)
+-----------------------------------------------
+The numbers are useless until they reach the database. Some systems come and collect them (Prometheus scrapes an HTTP endpoint), others expect you to send them. StartFlusher does the sending: once per interval it takes a snapshot of every metric and hands it to a sink function, which writes it wherever it needs to go.
+
+import (
+    "context"
+    "sort"
+    "strconv"
+    "time"
+)
+
+// Sample is one value at one point in time, ready for a time series database.
+type Sample struct {
+    Name  string
+    Value float64
+    Time  time.Time
+}
+
+// StartFlusher sends a snapshot of every metric to sink once per interval,
+// and one last time when ctx is cancelled. Counters and histograms report
+// what happened since the previous flush and start again from zero; gauges
+// report their current value. The returned channel is closed once the last
+// snapshot has gone to sink.
+func (m *Metrics) StartFlusher(ctx context.Context, interval time.Duration, sink func([]Sample)) <-chan struct{} {
+    done := make(chan struct{})
+    go func() {
+        defer close(done)
+        ticker := time.NewTicker(interval)
+        defer ticker.Stop()
+        for {
+            select {
+            case <-ticker.C:
+                sink(m.flush())
+            case <-ctx.Done():
+                sink(m.flush()) // don't lose the last partial interval
+                return
+            }
+        }
+    }()
+    return done
+}
+
+func (m *Metrics) flush() []Sample {
+    now := time.Now()
+    var samples []Sample
+    add := func(name string, v float64) {
+        samples = append(samples, Sample{Name: name, Value: v, Time: now})
+    }
+
+    m.mu.Lock()
+    defer m.mu.Unlock()
+
+    for name, c := range m.counters {
+        add(name, float64(c.n.Swap(0))) // read and reset in one step, so no Inc is lost
+    }
+    for name, g := range m.gauges {
+        add(name, g.Value())
+    }
+    for name, h := range m.histograms {
+        h.mu.Lock()
+        var cumulative int64
+        for i, n := range h.counts {
+            le := "+Inf"
+            if i < len(h.bounds) {
+                le = strconv.FormatFloat(h.bounds[i], 'g', -1, 64)
+            }
+            cumulative += n // le_X counts every value <= X, as Prometheus does
+            add(name+".le_"+le, float64(cumulative))
+            h.counts[i] = 0
+        }
+        add(name+".sum", h.sum)
+        add(name+".count", float64(h.count))
+        h.sum, h.count = 0, 0
+        h.mu.Unlock()
+    }
+
+    sort.Slice(samples, func(i, j int) bool { return samples[i].Name < samples[j].Name })
+    return samples
+}
+
+Using it:
+
+ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+defer stop()
+
+flushed := metrics.StartFlusher(ctx, 10*time.Second, func(samples []Sample) {
+    // Graphite's plain text format: one "name value timestamp" line per sample
+    var buf bytes.Buffer
+    for _, s := range samples {
+        fmt.Fprintf(&buf, "myapp.%s %g %d\n", s.Name, s.Value, s.Time.Unix())
+    }
+    if err := sendToGraphite(buf.Bytes()); err != nil {
+        log.Printf("metrics: %v", err) // drop this batch, the next one comes in 10s
+    }
+})
+
+// ... run the server until ctx is cancelled ...
+
+<-flushed // the last snapshot is out, now main may return
+
+Every 10 seconds that sends lines like:
+
+myapp.http.duration_seconds.le_0.05 412 1715072400
+myapp.http.duration_seconds.le_+Inf 455 1715072400
+myapp.http.requests 455 1715072400
+myapp.db.open_connections 14 1715072400
+
+What's happening in that code?
+Deltas for counters: Swap(0) returns the count and resets it in one atomic step. An Inc that happens at the same moment lands either in this interval or the next one, never in neither. The sink gets "455 requests in the last 10 seconds", which is what push-based systems like Graphite and StatsD expect.
+
+Histograms reset too: The buckets, sum and count start from zero after every flush, so each snapshot describes only its own interval. The buckets are cumulative, like Prometheus's: le_0.05 counts every value up to 0.05, including those already counted in le_0.01, and le_+Inf always equals count. "How many requests took at most 50ms" is then a single number, with no adding up.
+
+Gauges don't reset: A gauge is a current value, so the snapshot just reads it.
+
+The last flush: When ctx is cancelled, the flusher sends one more snapshot before it returns, so the requests of the final few seconds before a shutdown aren't lost. That only helps if the program is still running by then. The returned channel is closed after that last sink call, and main waits for it before it returns; without the wait, main could exit while the sink is still sending.
+
+Important: The sink runs on the flusher's goroutine, one call at a time. A sink that blocks delays the next snapshot, so give it a timeout (an http.Client with Timeout, for example). Because counters are reset on every flush, only one flusher should run per registry. A second one would steal half the counts from the first.
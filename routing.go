@@ -0,0 +1,459 @@
+Routing in Go
+=============
+
+http.HandleFunc is enough for a server with one or two pages. The CRUD API in connecting-to-databases.go already needs more. GET /users lists users, POST /users creates one, GET /users/{id} fetches one, and so on. Deciding which handler runs for which method and path is called routing.
+
+Since Go 1.22 the standard http.ServeMux understands methods and path parameters directly:
+
+mux := http.NewServeMux()
+mux.HandleFunc("GET /users/{id}", getUser)
+
+func getUser(w http.ResponseWriter, r *http.Request) {
+    id := r.PathValue("id") // "42" for GET /users/42
+    ...
+}
+
+That covers the matching. The Router in this file is a thin layer on top of ServeMux that also remembers what was registered, so we can add features around it.
+
+
+1. A Small Router
+-----------------
+import (
+    "net/http"
+)
+
+type Router struct {
+    mux          *http.ServeMux
+    routes       []RouteInfo
+    maxBodyBytes int64 // default for routes without their own limit, 0 means none
+    autoOptions  bool
+}
+
+func NewRouter() *Router {
+    return &Router{mux: http.NewServeMux()}
+}
+
+// RouteOption changes how a single route behaves, e.g. MaxBodyBytes(10 << 20).
+type RouteOption func(*routeConfig)
+
+type routeConfig struct {
+    maxBodyBytes int64
+    cache        *cachePolicy // nil means the handler decides
+}
+
+// Handle registers h for one method and path, e.g. ("GET", "/users/{id}").
+func (rt *Router) Handle(method, pattern string, h http.Handler, opts ...RouteOption) {
+    var cfg routeConfig
+    for _, opt := range opts {
+        opt(&cfg)
+    }
+
+    rt.mux.Handle(method+" "+pattern, cacheHeaders(rt.limitBody(h, cfg), cfg.cache))
+    rt.routes = append(rt.routes, RouteInfo{Method: method, Pattern: pattern, Handler: handlerName(h)})
+}
+
+func (rt *Router) HandleFunc(method, pattern string, h http.HandlerFunc, opts ...RouteOption) {
+    rt.Handle(method, pattern, h, opts...)
+}
+
+func (rt *Router) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+    if rt.autoOptions && rt.serveAuto(w, r) {
+        return
+    }
+    rt.mux.ServeHTTP(w, r)
+}
+
+Using it:
+
+router := NewRouter()
+router.HandleFunc("GET", "/users", getUsers)
+router.HandleFunc("POST", "/users", createUser)
+router.HandleFunc("GET", "/users/{id}", getUser)
+
+log.Fatal(http.ListenAndServe(":8080", router))
+
+Because Router has a ServeHTTP method it is an http.Handler itself, so it can be passed to ListenAndServe or wrapped in middleware like Logger(router).
+
+
+2. Listing Every Route
+----------------------
+Documentation about which endpoints exist goes out of date the moment someone adds a route and forgets to update it. The Router already knows every route, so it can simply tell us.
+
+import (
+    "encoding/json"
+    "fmt"
+    "net/http"
+    "reflect"
+    "runtime"
+    "sort"
+    "strings"
+)
+
+type RouteInfo struct {
+    Method  string `json:"method"`
+    Pattern string `json:"pattern"`
+    Handler string `json:"handler"`
+}
+
+// Routes returns every registered route, sorted by pattern and then method.
+func (rt *Router) Routes() []RouteInfo {
+    routes := make([]RouteInfo, len(rt.routes))
+    copy(routes, rt.routes)
+    sort.Slice(routes, func(i, j int) bool {
+        if routes[i].Pattern != routes[j].Pattern {
+            return routes[i].Pattern < routes[j].Pattern
+        }
+        return routes[i].Method < routes[j].Method
+    })
+    return routes
+}
+
+// RoutesHandler renders Routes() as JSON.
+func (rt *Router) RoutesHandler(w http.ResponseWriter, r *http.Request) {
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(rt.Routes())
+}
+
+// handlerName returns "main.getUsers" for functions and the type name otherwise.
+func handlerName(h http.Handler) string {
+    if v := reflect.ValueOf(h); v.Kind() == reflect.Func {
+        if fn := runtime.FuncForPC(v.Pointer()); fn != nil {
+            // Method values like rt.RoutesHandler get a "-fm" suffix
+            return strings.TrimSuffix(fn.Name(), "-fm")
+        }
+    }
+    return fmt.Sprintf("%T", h)
+}
+
+Add the listing as a route of its own:
+
+router.HandleFunc("GET", "/routes", router.RoutesHandler)
+
+curl http://localhost:8080/routes
+[
+  {"method":"GET","pattern":"/routes","handler":"main.(*Router).RoutesHandler"},
+  {"method":"GET","pattern":"/users","handler":"main.getUsers"},
+  {"method":"POST","pattern":"/users","handler":"main.createUser"},
+  {"method":"GET","pattern":"/users/{id}","handler":"main.getUser"}
+]
+
+What's happening in that code?
+Returning a copy: Routes hands out a copy of the slice, so a caller can sort or modify the result without changing the Router's own list.
+
+reflect and runtime.FuncForPC: A handler registered with HandleFunc is just a function value. reflect gives us the address of its code, and runtime.FuncForPC turns that address back into the name the compiler gave it. Handlers that are structs (like the SSE Hub) are reported by their type name instead, e.g. *main.Hub.
+
+Important: Register all routes before the server starts. The Router is not protected by a mutex, because adding routes while requests are being served isn't something it is meant to support.
+
+
+3. Request Body Limits per Route
+--------------------------------
+By default Go reads a request body for as long as the client keeps sending. Anyone can POST a few gigabytes to your JSON endpoint and make json.Decode chew through all of it. The fix is http.MaxBytesReader, which makes reading fail once a limit is reached.
+
+One limit rarely fits every route, though. A JSON API is happy with 1MB, while an avatar upload needs 10MB. So the Router lets you set a default and override it per route with an option (the RouteOption type in section 1):
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "net/http"
+)
+
+// MaxBodyBytes limits the request body size for one route, overriding the
+// Router's default.
+func MaxBodyBytes(n int64) RouteOption {
+    return func(c *routeConfig) { c.maxBodyBytes = n }
+}
+
+// DefaultMaxBodyBytes sets the limit for every route without its own.
+func (rt *Router) DefaultMaxBodyBytes(n int64) {
+    rt.maxBodyBytes = n
+}
+
+func (rt *Router) limitBody(h http.Handler, cfg routeConfig) http.Handler {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        limit := cfg.maxBodyBytes
+        if limit == 0 {
+            limit = rt.maxBodyBytes // read at request time, so the order of calls doesn't matter
+        }
+        if limit <= 0 {
+            h.ServeHTTP(w, r)
+            return
+        }
+
+        // Reject early when the client tells us the size up front
+        if r.ContentLength > limit {
+            writeBodyTooLarge(w, limit)
+            return
+        }
+        // Otherwise (e.g. chunked uploads) stop reading once the limit is hit
+        r.Body = http.MaxBytesReader(w, r.Body, limit)
+        h.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), bodyLimitKey{}, limit)))
+    })
+}
+
+type bodyLimitKey struct{}
+
+// BodyLimit returns the body limit the Router set for r's route, and false
+// if r didn't come through a Router with one.
+func BodyLimit(r *http.Request) (int64, bool) {
+    limit, ok := r.Context().Value(bodyLimitKey{}).(int64)
+    return limit, ok
+}
+
+// WriteBodyTooLarge answers with the Router's 413 if err means the body went
+// over the limit of r's route, and reports whether it did. DecodeJSON calls
+// it; handlers that read the body themselves should too.
+func WriteBodyTooLarge(w http.ResponseWriter, r *http.Request, err error) bool {
+    var maxErr *http.MaxBytesError
+    if _, limited := BodyLimit(r); !limited || !errors.As(err, &maxErr) {
+        return false
+    }
+    writeBodyTooLarge(w, maxErr.Limit)
+    return true
+}
+
+func writeBodyTooLarge(w http.ResponseWriter, limit int64) {
+    writeJSONError(w, CodeTooLarge, fmt.Sprintf("request body must not be larger than %d bytes", limit))
+}
+
+// writeJSONError answers with code's status and a JSON error, through
+// writeAppError from Error-handling.go.
+func writeJSONError(w http.ResponseWriter, code ErrorCode, message string) {
+    writeAppError(w, &AppError{Code: code, Message: message})
+}
+
+Using it:
+
+router := NewRouter()
+router.DefaultMaxBodyBytes(1 << 20) // 1MB for everything...
+
+router.HandleFunc("POST", "/users", createUser)
+router.HandleFunc("POST", "/avatars", uploadAvatar, MaxBodyBytes(10<<20)) // ...except uploads
+
+A client that sends too much gets:
+
+HTTP/1.1 413 Request Entity Too Large
+{"error":{"code":"too_large","message":"request body must not be larger than 1048576 bytes"}}
+
+What's happening in that code?
+Options: MaxBodyBytes returns a RouteOption, a small function that sets one field of the route's config. Later features can add more options without changing Handle's signature again, and routes that don't need any just leave them out.
+
+Two checks: Most clients send a Content-Length header, so oversized requests are rejected before a single byte of the body is read. Clients that stream the body without one get the MaxBytesReader instead.
+
+BodyLimit: The limit also goes into the request context, so code further in can see that the body is already capped. DecodeJSON (Error-handling.go) uses it to leave the body alone on routes the Router limits, instead of putting its own 1MB cap on top of an upload route's 10MB.
+
+When MaxBytesReader triggers: The Router can't answer by itself then, because it's the handler's read that fails, with an *http.MaxBytesError. DecodeJSON hands that error to WriteBodyTooLarge, which writes the same JSON 413 as the Content-Length check. A handler that reads the body some other way does the same:
+
+data, err := io.ReadAll(r.Body)
+if WriteBodyTooLarge(w, r, err) {
+    return // the 413 is sent
+}
+
+Important: 1<<20 is 1,048,576 bytes (1MB) and 10<<20 is 10MB. Shifting is a common Go idiom for sizes. Set a default limit on every server, because forgetting one route is enough to be vulnerable.
+
+
+4. Automatic OPTIONS and HEAD
+-----------------------------
+Two methods are rarely worth writing by hand. Browsers send OPTIONS before some cross-origin requests to ask what a URL supports, and monitoring tools often probe an endpoint with HEAD, which must answer like GET but without the body.
+
+HEAD needs nothing from us: ServeMux already routes it to the GET handler, and net/http drops the body the handler writes while keeping the headers, including the Content-Length it would have sent. For OPTIONS the mux only answers 405, because nobody registered it. With AutoOptions turned on, the Router answers it:
+
+import (
+    "net/http"
+    "slices"
+    "strings"
+)
+
+// AutoOptions makes the Router answer OPTIONS requests with the methods
+// registered for the path.
+func (rt *Router) AutoOptions(on bool) {
+    rt.autoOptions = on
+}
+
+// serveAuto handles r itself and returns true, or returns false to let the
+// mux handle it as usual.
+func (rt *Router) serveAuto(w http.ResponseWriter, r *http.Request) bool {
+    if r.Method != http.MethodOptions {
+        return false
+    }
+    if _, pattern := rt.mux.Handler(r); pattern != "" {
+        return false // the route has its own OPTIONS handler
+    }
+    allowed := rt.allowedMethods(r)
+    if len(allowed) == 0 {
+        return false // unknown path, let the mux send 404
+    }
+    w.Header().Set("Allow", strings.Join(allowed, ", "))
+    w.WriteHeader(http.StatusNoContent)
+    return true
+}
+
+// allowedMethods asks the mux, method by method, which ones match r's path.
+func (rt *Router) allowedMethods(r *http.Request) []string {
+    var allowed []string
+    for _, route := range rt.routes {
+        if slices.Contains(allowed, route.Method) {
+            continue
+        }
+        probe := *r
+        probe.Method = route.Method
+        if _, pattern := rt.mux.Handler(&probe); pattern != "" {
+            allowed = append(allowed, route.Method)
+        }
+    }
+    if len(allowed) == 0 {
+        return nil
+    }
+    if slices.Contains(allowed, http.MethodGet) && !slices.Contains(allowed, http.MethodHead) {
+        allowed = append(allowed, http.MethodHead)
+    }
+    allowed = append(allowed, http.MethodOptions)
+    slices.Sort(allowed)
+    return allowed
+}
+
+ServeHTTP in section 1 already calls serveAuto when AutoOptions is on.
+
+Using it:
+
+router := NewRouter()
+router.AutoOptions(true)
+router.HandleFunc("GET", "/users/{id}", getUser)
+router.HandleFunc("DELETE", "/users/{id}", deleteUser)
+
+$ curl -i -X OPTIONS localhost:8080/users/42
+HTTP/1.1 204 No Content
+Allow: DELETE, GET, HEAD, OPTIONS
+
+$ curl -I localhost:8080/users/42
+HTTP/1.1 200 OK
+Content-Length: 52
+Content-Type: application/json
+
+What's happening in that code?
+Asking the mux: rt.mux.Handler(r) returns the pattern that would handle a request, or "" if there is none. allowedMethods copies the request, changes only the method, and asks once per registered method. That way wildcards like {id} and the mux's own precedence rules work without the Router parsing patterns itself.
+
+Your own OPTIONS wins: If the path has an OPTIONS route registered, serveAuto steps aside. A CORS middleware that answers preflights with the right Access-Control headers still sees the request first if it wraps the Router.
+
+HEAD is left to net/http: The GET handler runs as usual, and the server throws the body away after counting it, so Content-Length and Content-Type come out as they would for GET. Wrapping the ResponseWriter to drop the writes ourselves would lose that Content-Length and hide http.Flusher from handlers that stream.
+
+Important: HEAD still does all the work of GET, including the database query. That's usually fine. For an expensive endpoint, register a HEAD route of its own that skips building the body. The OPTIONS reply is 204 with an Allow header and nothing else, so when a browser preflights a cross-origin request, you still need CORS headers to make it succeed.
+
+
+5. Cache-Control per Route
+--------------------------
+Browsers and CDNs decide whether to reuse a response by reading its Cache-Control header. Without one they guess, and a guess is wrong in both directions: a list of books that could be cached for minutes is fetched on every click, while a proxy might keep an old copy of a response that should never have been stored. Setting the header in every handler works until one handler forgets.
+
+The Router can take care of it with two more route options, next to MaxBodyBytes from section 3:
+
+import (
+    "net/http"
+    "strconv"
+    "time"
+)
+
+type cachePolicy struct {
+    maxAge  time.Duration
+    noStore bool
+}
+
+// CacheFor lets browsers and CDNs reuse successful responses of the route for d.
+func CacheFor(d time.Duration) RouteOption {
+    return func(c *routeConfig) { c.cache = &cachePolicy{maxAge: d} }
+}
+
+// NoStore tells browsers and CDNs never to keep a copy of the route's responses.
+func NoStore() RouteOption {
+    return func(c *routeConfig) { c.cache = &cachePolicy{noStore: true} }
+}
+
+The cache field of routeConfig holds the policy, and Handle wraps every route with cacheHeaders (see section 1):
+
+func cacheHeaders(h http.Handler, policy *cachePolicy) http.Handler {
+    if policy == nil {
+        return h
+    }
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        h.ServeHTTP(&cacheWriter{ResponseWriter: w, policy: policy}, r)
+    })
+}
+
+// cacheWriter adds the headers just before the status goes out, when the
+// handler's status code and its own headers are known.
+type cacheWriter struct {
+    http.ResponseWriter
+    policy      *cachePolicy
+    wroteHeader bool
+}
+
+func (w *cacheWriter) WriteHeader(status int) {
+    if !w.wroteHeader {
+        w.wroteHeader = true
+        w.setHeaders(status)
+    }
+    w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *cacheWriter) Write(b []byte) (int, error) {
+    if !w.wroteHeader {
+        w.WriteHeader(http.StatusOK)
+    }
+    return w.ResponseWriter.Write(b)
+}
+
+// Flush sends the headers first, so streaming routes (SSE, ChunkedWriter)
+// keep working with a cache option.
+func (w *cacheWriter) Flush() {
+    if !w.wroteHeader {
+        w.WriteHeader(http.StatusOK)
+    }
+    if f, ok := w.ResponseWriter.(http.Flusher); ok {
+        f.Flush()
+    }
+}
+
+func (w *cacheWriter) Unwrap() http.ResponseWriter {
+    return w.ResponseWriter
+}
+
+func (w *cacheWriter) setHeaders(status int) {
+    h := w.Header()
+    if h.Get("Cache-Control") != "" {
+        return // the handler set its own, it knows best
+    }
+    // An error page must not be served from a cache for the next five minutes
+    if w.policy.noStore || status >= 400 {
+        h.Set("Cache-Control", "no-store")
+        return
+    }
+    seconds := int64(w.policy.maxAge / time.Second)
+    h.Set("Cache-Control", "public, max-age="+strconv.FormatInt(seconds, 10))
+    h.Set("Expires", time.Now().Add(w.policy.maxAge).UTC().Format(http.TimeFormat))
+}
+
+Using it:
+
+router := NewRouter()
+router.HandleFunc("GET", "/books", getBooks, CacheFor(5*time.Minute))
+router.HandleFunc("GET", "/books/{id}", getBook, CacheFor(time.Minute))
+router.HandleFunc("POST", "/books", createBook, NoStore())
+router.HandleFunc("GET", "/me", getProfile, NoStore()) // personal data, never in a shared cache
+
+$ curl -i localhost:8080/books
+HTTP/1.1 200 OK
+Cache-Control: public, max-age=300
+Content-Type: application/json
+Expires: Thu, 15 Oct 2026 12:05:00 GMT
+
+$ curl -i localhost:8080/books/999
+HTTP/1.1 404 Not Found
+Cache-Control: no-store
+
+What's happening in that code?
+Headers at WriteHeader time: Headers have to be set before the status line is sent, but the policy depends on the status. cacheWriter waits for the first WriteHeader or Write, looks at the status, and only then adds Cache-Control. That's the same trick headResponseWriter in section 4 uses. Flush passes through to the real writer, so a streaming handler behind CacheFor or NoStore can still flush, and Unwrap keeps http.ResponseController working through it.
+
+The handler wins: If a handler sets Cache-Control itself (for example a route that is usually cacheable but has one private variant), the Router leaves it alone. The option is a default, not an override.
+
+Max-age and Expires: max-age is what every modern cache reads. Expires is the older absolute date from HTTP/1.0, still honoured by a few proxies. When both are present max-age wins, so the two never disagree in a way that matters.
+
+Important: "public" allows shared caches such as CDNs to store the response. Only use CacheFor on routes whose answer is the same for every user. Anything that depends on the session or an Authorization header should be NoStore, or set "private, max-age=..." in the handler itself. Combine CacheFor with Last-Modified from http-handler-helpers.go, so that once max-age runs out the browser can revalidate with a cheap 304 instead of downloading the whole list again.
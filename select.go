@@ -12,4 +12,181 @@ Timeouts: As seen above, you can use time.After to make sure your program doesn'
 
 Non-blocking: You can add a default case to a select. If no channels are ready, the default runs immediately instead of waiting.
 
-Synchronization: It’s the perfect way to manage "Quit" signals. You can have one channel for data and one channel for a "Shutdown" command.
\ No newline at end of file
+Synchronization: It’s the perfect way to manage "Quit" signals. You can have one channel for data and one channel for a "Shutdown" command.
+
+2. Collecting the First n Results (Take)
+A select inside a loop is also how you stop waiting. In the Fast Downloader from goroutines.go we read exactly three results. Often you only need the fastest two of three, or as many as arrive within a second. Take collects up to n values and gives up early on a timeout, a closed channel, or a cancelled context. TakeLast does the opposite and keeps only the most recent n.
+
+import (
+    "context"
+    "time"
+)
+
+// Take receives up to n values from in. It returns early, with the values it
+// has so far, when in is closed or timeout passes (a timeout <= 0 means no
+// timeout). The error is only set when ctx is cancelled.
+func Take[T any](ctx context.Context, in <-chan T, n int, timeout time.Duration) ([]T, error) {
+    out := make([]T, 0, n)
+    expired := deadline(timeout)
+    for len(out) < n {
+        select {
+        case v, ok := <-in:
+            if !ok {
+                return out, nil
+            }
+            out = append(out, v)
+        case <-expired:
+            return out, nil
+        case <-ctx.Done():
+            return out, ctx.Err()
+        }
+    }
+    return out, nil
+}
+
+// TakeLast receives from in until it is closed or timeout passes, and
+// returns the last n values, oldest first.
+func TakeLast[T any](ctx context.Context, in <-chan T, n int, timeout time.Duration) ([]T, error) {
+    last := make([]T, 0, n)
+    expired := deadline(timeout)
+    for {
+        select {
+        case v, ok := <-in:
+            if !ok {
+                return last, nil
+            }
+            if n <= 0 {
+                continue
+            }
+            if len(last) == n {
+                last = append(last[:0], last[1:]...) // drop the oldest
+            }
+            last = append(last, v)
+        case <-expired:
+            return last, nil
+        case <-ctx.Done():
+            return last, ctx.Err()
+        }
+    }
+}
+
+// deadline returns a channel that fires after d, or nil (which blocks forever
+// in a select) when d <= 0.
+func deadline(d time.Duration) <-chan time.Time {
+    if d <= 0 {
+        return nil
+    }
+    return time.After(d)
+}
+
+Using it with the downloader:
+
+c := make(chan DownloadResult, 3) // buffered, so the slow download can still finish and exit
+go downloadSite("https://google.com", c)
+go downloadSite("https://amazon.com", c)
+go downloadSite("https://github.com", c)
+
+fastest, err := Take(ctx, c, 2, 3*time.Second)
+if err != nil {
+    return err // ctx was cancelled
+}
+for _, r := range fastest { // the first two that finished, or fewer if 3 seconds passed
+    fmt.Println(r.URL, r.Duration)
+}
+
+What's happening in that code?
+Three cases in one select: Whichever happens first wins, exactly like in section 1: a value arrives, the timer fires, or ctx is cancelled.
+
+A nil channel: When there is no timeout, deadline returns nil. Receiving from a nil channel blocks forever, so that case simply never runs and the select waits on the other two.
+
+Short results are not errors: Getting fewer than n values because of the timeout or a closed channel is the normal "good enough" answer, so check len(result). Only cancellation returns an error, because then the caller asked us to stop.
+
+Important: Take stops reading, but the goroutines it didn't wait for keep running. If they send on an unbuffered channel nobody reads anymore, they block forever and leak. Give the channel room for every sender (as above), or pass them the same ctx so they can quit.
+
+
+3. Dropping Repeats from a Stream (Dedup)
+A channel of events is often noisy: a sensor reports the same alarm every second, a webhook provider delivers the same event twice, a file watcher fires three times for one save. Dedup is a stage you put between the producer and the consumer. It passes each value on, but drops those whose key was already passed on within the last window.
+
+import (
+    "context"
+    "time"
+)
+
+type seenKey[K comparable] struct {
+    key K
+    at  time.Time
+}
+
+// Dedup forwards the values from in, except those whose key was forwarded
+// less than window ago. The output is closed when in is closed or ctx is
+// cancelled.
+func Dedup[T any, K comparable](ctx context.Context, in <-chan T, key func(T) K, window time.Duration) <-chan T {
+    out := make(chan T)
+    go func() {
+        defer close(out)
+        seen := make(map[K]time.Time)
+        var order []seenKey[K] // oldest first, so expired keys are always at the front
+
+        for {
+            var v T
+            select {
+            case x, ok := <-in:
+                if !ok {
+                    return
+                }
+                v = x
+            case <-ctx.Done():
+                return
+            }
+
+            now := time.Now()
+            for len(order) > 0 && now.Sub(order[0].at) >= window {
+                delete(seen, order[0].key)
+                order = order[1:]
+            }
+
+            k := key(v)
+            if _, dup := seen[k]; dup {
+                continue
+            }
+            seen[k] = now
+            order = append(order, seenKey[K]{k, now})
+
+            select {
+            case out <- v:
+            case <-ctx.Done():
+                return
+            }
+        }
+    }()
+    return out
+}
+
+Using it for alerts, with at most one alert per host and check per minute:
+
+type Alert struct {
+    Host, Check, Message string
+}
+
+alerts := make(chan Alert)
+go watchHosts(ctx, alerts) // sends an Alert every few seconds while something is wrong
+
+deduped := Dedup(ctx, alerts, func(a Alert) string { return a.Host + "/" + a.Check }, time.Minute)
+for a := range deduped {
+    notifyOnCall(a) // one page per minute, not one every five seconds
+}
+
+Stages compose, because each one takes a channel and returns one:
+
+first5, err := Take(ctx, Dedup(ctx, events, eventID, time.Hour), 5, 10*time.Second)
+
+What's happening in that code?
+Two selects: The first one waits for a value or cancellation, the second one waits for the consumer to take the value or cancellation. Without ctx in the second select, a consumer that stopped reading would leave the goroutine blocked on out <- v forever.
+
+The window starts when a value is forwarded: A repeat that is dropped doesn't reset the clock. An alert that keeps firing every five seconds still gets through once a minute, so you're reminded that the problem is still there.
+
+Evicting in order: Every key in order was forwarded at its at time, and keys are appended as time goes on, so the oldest ones are always at the front. Each new value first throws away the keys older than window from the front, which keeps the map to the keys of the last window and never needs a scan over the whole map.
+
+The key function: The stage doesn't compare whole values (T may not even be comparable). key picks what counts as "the same", like an event ID, or a host plus a check name.
+
+Important: Memory is bounded by how many different keys arrive within one window, not by the total. A stream of a million unique IDs per hour with a one-hour window still keeps a million keys. Expired keys are only dropped when the next value arrives, so after a burst on a stream that then goes quiet the map stays as it is until the next value (or until ctx is cancelled and the goroutine exits).
@@ -0,0 +1,646 @@
+Streaming Responses in Go
+=========================
+
+Most handlers build a response and send it in one go. Some responses are different: live updates, huge exports, or files generated on the fly. For those you want to start sending data before you have all of it. The net/http package supports this through the http.Flusher interface, which pushes whatever you have written so far straight to the client.
+
+
+1. Server-Sent Events (SSE)
+---------------------------
+Server-Sent Events are the simplest way to push live updates to a browser. The client opens a normal GET request and the server keeps it open, writing events in a small text format:
+
+event: price
+data: {"symbol":"GOOG","price":172.5}
+
+(a blank line ends each event)
+
+In the browser it is just: new EventSource("/events")
+
+A small writer takes care of the headers and the format for us:
+
+import (
+    "fmt"
+    "net/http"
+    "strings"
+)
+
+type SSEWriter struct {
+    w       http.ResponseWriter
+    flusher http.Flusher
+}
+
+func NewSSEWriter(w http.ResponseWriter) (*SSEWriter, error) {
+    flusher, ok := w.(http.Flusher)
+    if !ok {
+        return nil, fmt.Errorf("sse: response writer does not support flushing")
+    }
+
+    w.Header().Set("Content-Type", "text/event-stream")
+    w.Header().Set("Cache-Control", "no-cache")
+    w.Header().Set("Connection", "keep-alive")
+    w.WriteHeader(http.StatusOK)
+    flusher.Flush()
+
+    return &SSEWriter{w: w, flusher: flusher}, nil
+}
+
+// Send writes one event. Multi-line data is split into several "data:" lines.
+func (s *SSEWriter) Send(event, data string) error {
+    if event != "" {
+        if _, err := fmt.Fprintf(s.w, "event: %s\n", event); err != nil {
+            return err
+        }
+    }
+    for _, line := range strings.Split(data, "\n") {
+        if _, err := fmt.Fprintf(s.w, "data: %s\n", line); err != nil {
+            return err
+        }
+    }
+    if _, err := fmt.Fprint(s.w, "\n"); err != nil {
+        return err
+    }
+    s.flusher.Flush()
+    return nil
+}
+
+Important: Flush() is what actually sends the bytes. Without it the event sits in a buffer and the browser sees nothing until the buffer fills up.
+
+
+2. Broadcasting to Many Clients (The Hub)
+-----------------------------------------
+One SSEWriter serves one client. For a live dashboard you want to send the same event to everyone who is connected. A Hub keeps track of the clients and fans each Broadcast out to all of them.
+
+The tricky part is backpressure. If one client is on a slow phone connection and we wait for it, every other client waits too. So each client gets a small buffered channel. Broadcast uses select with a default case so it never blocks. If a client's buffer is full, the hub drops that client and closes its connection instead of holding everyone else up.
+
+import (
+    "encoding/json"
+    "net/http"
+    "sync"
+)
+
+type message struct {
+    event string
+    data  string
+}
+
+type client struct {
+    send chan message
+    done chan struct{} // closed by the hub when it drops this client
+}
+
+type Hub struct {
+    mu         sync.Mutex
+    clients    map[*client]struct{}
+    bufferSize int
+    cfg        config
+}
+
+func NewHub(bufferSize int, opts ...Option) *Hub {
+    if bufferSize <= 0 {
+        bufferSize = 16
+    }
+    return &Hub{clients: make(map[*client]struct{}), bufferSize: bufferSize, cfg: newConfig(opts)}
+}
+
+// Broadcast never blocks: a client whose buffer is full is dropped.
+func (h *Hub) Broadcast(event, data string) {
+    h.mu.Lock()
+    defer h.mu.Unlock()
+
+    for c := range h.clients {
+        select {
+        case c.send <- message{event: event, data: data}:
+        default:
+            // Too slow to keep up - drop it instead of blocking everyone else
+            h.remove(c)
+            h.cfg.logger.Warn("sse client dropped: send buffer full", "event", event, "buffer", h.bufferSize)
+        }
+    }
+}
+
+// ClientCount reports how many clients are currently connected.
+func (h *Hub) ClientCount() int {
+    h.mu.Lock()
+    defer h.mu.Unlock()
+    return len(h.clients)
+}
+
+// remove must be called with h.mu held.
+func (h *Hub) remove(c *client) {
+    if _, ok := h.clients[c]; ok {
+        delete(h.clients, c)
+        close(c.done)
+    }
+}
+
+// ServeHTTP subscribes the caller and streams events until they disconnect
+// or get dropped for being too slow.
+func (h *Hub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+    sse, err := NewSSEWriter(w)
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusInternalServerError)
+        return
+    }
+
+    c := &client{send: make(chan message, h.bufferSize), done: make(chan struct{})}
+    h.mu.Lock()
+    h.clients[c] = struct{}{}
+    h.mu.Unlock()
+
+    defer func() {
+        h.mu.Lock()
+        h.remove(c)
+        h.mu.Unlock()
+    }()
+
+    for {
+        select {
+        case msg := <-c.send:
+            if err := sse.Send(msg.event, msg.data); err != nil {
+                return // client went away
+            }
+        case <-c.done:
+            return // dropped by Broadcast; returning closes the connection
+        case <-r.Context().Done():
+            return
+        }
+    }
+}
+
+// MetricsHandler reports the number of connected clients as JSON.
+func (h *Hub) MetricsHandler(w http.ResponseWriter, r *http.Request) {
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(map[string]int{"sse_clients": h.ClientCount()})
+}
+
+Using it:
+
+hub := NewHub(32) // each client can fall 32 events behind before it is dropped
+
+http.Handle("/events", hub)
+http.HandleFunc("/metrics/sse", hub.MetricsHandler)
+
+// Anywhere in your program:
+hub.Broadcast("price", `{"symbol":"GOOG","price":172.5}`)
+
+What's happening in that code?
+The send channel: This is the client's buffer. Broadcast drops events into it and the client's own goroutine (inside ServeHTTP) writes them out at its own pace.
+
+select with default: Normally, sending on a full channel blocks. Adding a default case turns the send into "send if you can, otherwise do something else", which is exactly the non-blocking behavior we need (see select.go).
+
+The done channel: Closing a channel wakes up everyone waiting on it. When the hub drops a slow client it closes done, ServeHTTP returns, and net/http closes the connection for us.
+
+r.Context().Done(): This fires when the browser tab closes, so we clean up clients that left on their own.
+
+Important: Browsers reconnect automatically when an EventSource connection drops. A dropped client will come back with a fresh, empty buffer, which is usually what you want.
+
+
+3. Exports That Stop When the Client Leaves (CopyContext)
+---------------------------------------------------------
+A CSV export of every order ever placed can run for minutes. If the user closes the tab after ten seconds, io.Copy doesn't notice. It keeps reading rows from the database and writing them to a connection nobody listens to, until the whole export is done.
+
+CopyContext is io.Copy with a check of ctx between chunks. Pass it r.Context(), which net/http cancels when the client disconnects:
+
+import (
+    "context"
+    "io"
+)
+
+// CopyContext is io.Copy that checks ctx between 32KB chunks and stops with
+// ctx.Err() once it is cancelled.
+func CopyContext(ctx context.Context, dst io.Writer, src io.Reader) (int64, error) {
+    buf := make([]byte, 32*1024)
+    var written int64
+    for {
+        if err := ctx.Err(); err != nil {
+            return written, err
+        }
+
+        n, readErr := src.Read(buf)
+        if n > 0 {
+            w, err := dst.Write(buf[:n])
+            written += int64(w)
+            if err != nil {
+                return written, err
+            }
+            if w < n {
+                return written, io.ErrShortWrite
+            }
+        }
+        if readErr == io.EOF {
+            return written, nil
+        }
+        if readErr != nil {
+            return written, readErr
+        }
+    }
+}
+
+Most exports aren't an io.Reader. They are a loop that writes rows. StreamExport connects the two with io.Pipe: produce writes into one end in its own goroutine, and CopyContext copies the other end to the client.
+
+import (
+    "context"
+    "io"
+    "net/http"
+)
+
+// StreamExport sends whatever produce writes to the client as it is written.
+// produce runs in its own goroutine; when the client disconnects, its next
+// write fails and ctx is cancelled, so a huge export stops early, and
+// StreamExport returns an error wrapping ErrClientGone.
+func StreamExport(w http.ResponseWriter, r *http.Request, contentType string,
+    produce func(ctx context.Context, w io.Writer) error) error {
+
+    ctx, cancel := context.WithCancel(r.Context())
+    defer cancel()
+
+    pr, pw := io.Pipe()
+    done := make(chan error, 1)
+    go func() {
+        err := produce(ctx, pw)
+        pw.CloseWithError(err) // nil closes normally, so the copy sees EOF
+        done <- err
+    }()
+
+    w.Header().Set("Content-Type", contentType)
+    _, copyErr := CopyContext(ctx, w, pr)
+
+    // Unblock the producer if we stopped early, then wait for it to finish
+    pr.CloseWithError(copyErr)
+    cancel()
+    produceErr := <-done
+
+    if copyErr != nil {
+        return clientGone(copyErr)
+    }
+    return clientGone(produceErr)
+}
+
+Using it for CSV, with StreamCursor and its orderRow from database-recipes.go:
+
+func exportOrders(w http.ResponseWriter, r *http.Request) {
+    w.Header().Set("Content-Disposition", `attachment; filename="orders.csv"`)
+    err := StreamExport(w, r, "text/csv", func(ctx context.Context, out io.Writer) error {
+        tx, err := db.BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
+        if err != nil {
+            return err
+        }
+        defer tx.Rollback()
+
+        cw := csv.NewWriter(out)
+        err = StreamCursor(ctx, tx, "orders_export", "SELECT id, total FROM orders", 1000, func(ctx context.Context, batch []orderRow) error {
+            for _, o := range batch {
+                if err := cw.Write([]string{strconv.Itoa(o.ID), fmt.Sprint(o.Total)}); err != nil {
+                    return err
+                }
+            }
+            return nil
+        })
+        cw.Flush()
+        if err != nil {
+            return err
+        }
+        return cw.Error()
+    })
+    if err != nil && !errors.Is(err, ErrClientGone) {
+        log.Printf("export orders: %v", err)
+    }
+}
+
+JSON works the same way: create a json.NewEncoder(out) inside produce and call Encode once per row to write JSON Lines (Content-Type application/x-ndjson).
+
+What's happening in that code?
+Checking between chunks: A Read or Write that is already running can't be interrupted from outside, but each one only handles 32KB. Checking ctx before every chunk means the copy stops almost immediately after the client leaves.
+
+io.Pipe: Writes to pw block until CopyContext reads them from pr, so the producer can never run ahead of the client and nothing piles up in memory.
+
+Stopping the producer: When the copy stops early, pr.CloseWithError makes the producer's next write fail, and cancel() stops a query that is waiting on the database. StreamExport then waits for produce to return, so the transaction is rolled back before the handler finishes.
+
+Important: The 200 status and headers go out with the first chunk. If the database fails halfway through, it is too late to send a 500, and the client just gets a truncated file. Log the error as above. For files that must be complete, write a last line (such as a row count) the client can check.
+
+
+4. Several Files in One Response (multipart/mixed)
+--------------------------------------------------
+A monthly report is often three files: orders.csv, refunds.csv and a summary.json. Sending a zip means building the archive first, and asking the client to make three requests means three times the work. A multipart/mixed response sends all of them in one body, each as its own part with its own headers, separated by a boundary string, the same format email uses for attachments.
+
+import (
+    "context"
+    "io"
+    "mime"
+    "mime/multipart"
+    "net/http"
+    "net/textproto"
+)
+
+// MultipartWriter sends several files in one multipart/mixed response,
+// streaming each one as it is written.
+type MultipartWriter struct {
+    w  http.ResponseWriter
+    mw *multipart.Writer
+}
+
+// NewMultipartWriter sets the Content-Type (with its boundary) on w. Call it
+// before writing anything else.
+func NewMultipartWriter(w http.ResponseWriter) *MultipartWriter {
+    mw := multipart.NewWriter(w)
+    w.Header().Set("Content-Type", mime.FormatMediaType("multipart/mixed",
+        map[string]string{"boundary": mw.Boundary()}))
+    return &MultipartWriter{w: w, mw: mw}
+}
+
+// WriteFile adds one part and copies src into it until EOF or until ctx is
+// cancelled. A disconnected client is reported as ErrClientGone.
+func (m *MultipartWriter) WriteFile(ctx context.Context, filename, contentType string, src io.Reader) error {
+    header := make(textproto.MIMEHeader)
+    header.Set("Content-Type", contentType)
+    header.Set("Content-Disposition", mime.FormatMediaType("attachment",
+        map[string]string{"filename": filename}))
+
+    part, err := m.mw.CreatePart(header)
+    if err != nil {
+        return clientGone(err)
+    }
+    if _, err := CopyContext(ctx, part, src); err != nil {
+        return clientGone(err)
+    }
+    // Send the finished part now instead of when the buffer happens to fill
+    if f, ok := m.w.(http.Flusher); ok {
+        f.Flush()
+    }
+    return nil
+}
+
+// Close writes the final boundary. Without it the client sees the response
+// as cut off.
+func (m *MultipartWriter) Close() error {
+    return m.mw.Close()
+}
+
+Using it:
+
+func monthlyReport(w http.ResponseWriter, r *http.Request) {
+    ctx := r.Context()
+    mw := NewMultipartWriter(w)
+    defer mw.Close()
+
+    for _, name := range []string{"orders", "refunds"} {
+        pr, pw := io.Pipe()
+        go func() {
+            pw.CloseWithError(writeCSV(ctx, pw, name)) // writes the rows for one table
+        }()
+        err := mw.WriteFile(ctx, name+".csv", "text/csv", pr)
+        pr.CloseWithError(err) // stops writeCSV if the copy failed
+        if err != nil {
+            log.Printf("report %s: %v", name, err)
+            return
+        }
+    }
+
+    summary, _ := json.Marshal(buildSummary())
+    mw.WriteFile(ctx, "summary.json", "application/json", bytes.NewReader(summary))
+}
+
+The response looks like this:
+
+Content-Type: multipart/mixed; boundary=686e59e9f4097464d5c5
+
+--686e59e9f4097464d5c5
+Content-Disposition: attachment; filename="orders.csv"
+Content-Type: text/csv
+
+id,total
+...
+--686e59e9f4097464d5c5
+Content-Disposition: attachment; filename="refunds.csv"
+...
+--686e59e9f4097464d5c5--
+
+What's happening in that code?
+mime/multipart: The standard library already knows the format. multipart.Writer picks a random boundary, writes it between parts and adds the final "--" after the last one in Close. Our part is setting the response header and streaming into each part.
+
+No buffering: CreatePart returns an io.Writer that writes straight to the response. CopyContext (section 3) moves the data in 32KB chunks, so a 2GB CSV never sits in memory, and a client that disconnects stops the copy.
+
+mime.FormatMediaType: Builds the header value with proper quoting. For a filename with non-ASCII characters it switches to the filename*=utf-8'' form that clients understand.
+
+Important: Browsers don't show multipart/mixed responses as downloads; they are meant for API clients. In Go, read one with mime.ParseMediaType on the Content-Type and multipart.NewReader(resp.Body, params["boundary"]). For a download in a browser, a zip (archive/zip also streams) is the better choice.
+
+
+5. Long Polling
+---------------
+SSE needs a connection that stays open and a client that understands text/event-stream. Some clients can't do that: an old HTTP library, a proxy that buffers responses, a script with curl. Long polling works with plain requests. The client asks "anything new?", and instead of answering "no" straight away, the server holds the request until there is something new or a timeout passes. Then the client asks again.
+
+import (
+    "context"
+    "encoding/json"
+    "net/http"
+    "time"
+)
+
+// LongPoll calls check until it reports data, then sends that data as JSON.
+// If wait passes first it sends 204 No Content, and the client asks again.
+// The pause between checks grows from 100ms to 2s. It returns ctx.Err() if
+// the client goes away, and check's error without writing anything, so the
+// caller can send its own error response.
+func LongPoll(w http.ResponseWriter, r *http.Request, wait time.Duration,
+    check func(context.Context) (any, bool, error)) error {
+
+    ctx, cancel := context.WithTimeout(r.Context(), wait)
+    defer cancel()
+
+    pause := 100 * time.Millisecond
+    for {
+        data, ok, err := check(ctx)
+        if err != nil && ctx.Err() == nil {
+            return err
+        }
+        if ok {
+            w.Header().Set("Content-Type", "application/json")
+            return json.NewEncoder(w).Encode(data)
+        }
+
+        timer := time.NewTimer(pause)
+        select {
+        case <-timer.C:
+            pause = min(pause*2, 2*time.Second)
+        case <-ctx.Done():
+            timer.Stop()
+            if err := r.Context().Err(); err != nil {
+                return err // the client disconnected
+            }
+            w.WriteHeader(http.StatusNoContent) // nothing new within wait
+            return nil
+        }
+    }
+}
+
+Using it for "new messages since ID 120":
+
+func pollMessages(w http.ResponseWriter, r *http.Request) {
+    since, _ := strconv.Atoi(r.URL.Query().Get("since"))
+
+    err := LongPoll(w, r, 30*time.Second, func(ctx context.Context) (any, bool, error) {
+        msgs, err := messagesAfter(ctx, since) // SELECT ... WHERE id > ? ORDER BY id
+        return msgs, len(msgs) > 0, err
+    })
+    if err != nil && r.Context().Err() == nil {
+        WriteError(w, err) // Error-handling.go
+    }
+}
+
+The client's loop:
+
+for {
+    resp, err := http.Get("https://api.example.com/messages/poll?since=" + strconv.Itoa(lastID))
+    // 200: handle the messages and update lastID; 204: nothing new, just ask again
+}
+
+What's happening in that code?
+One deadline for everything: context.WithTimeout(r.Context(), wait) ends when the wait is over or when the client disconnects, whichever comes first. check gets the same ctx, so a slow query is cut off too.
+
+Backoff: Checking every 100ms at first makes new data show up quickly. If nothing happens, the pause doubles up to 2s, so a hundred idle clients cause a few dozen queries a second, not a thousand.
+
+Which one ended it: When ctx is done, r.Context().Err() tells the two cases apart. If the client is gone we just return; nobody is listening for a 204.
+
+Important: Keep wait below every timeout between you and the client. Load balancers often cut idle requests after 60 seconds, and the server's own WriteTimeout (if you set one) applies too. 30 seconds is a safe default. Each waiting client holds a goroutine but no database connection between checks, so thousands of pollers are fine. If check itself is expensive, have it read from something cheap, like a counter the writers update, rather than querying the database every time.
+
+
+6. Sending a Response Piece by Piece (ChunkedWriter)
+----------------------------------------------------
+SSE has its own format, and the export in section 3 is one long copy. Sometimes you just want to send plain output as it's produced: the log of a build that is running, the lines of a report that takes a minute to compute, the tokens of a generated text. Each piece should reach the client right away, not whenever a buffer fills up.
+
+HTTP/1.1 does this with chunked transfer encoding: instead of announcing the full length up front, the server sends the body in chunks, each with its own length, and an empty chunk at the end. net/http already does this whenever a handler writes without setting Content-Length. What's missing is the flushing after every write, and a clear error when flushing isn't possible:
+
+import (
+    "errors"
+    "net/http"
+)
+
+// ErrFlushNotSupported is returned when the ResponseWriter can't push data
+// out early, so a progressive response would arrive all at once.
+var ErrFlushNotSupported = errors.New("chunked: response writer does not support flushing")
+
+// ChunkedWriter sends every piece of the response to the client the moment
+// it is written.
+type ChunkedWriter struct {
+    w       http.ResponseWriter
+    flusher http.Flusher
+}
+
+// NewChunkedWriter prepares w for a response of unknown length. Set the
+// Content-Type and status before the first WriteChunk, as usual.
+func NewChunkedWriter(w http.ResponseWriter) (*ChunkedWriter, error) {
+    flusher, ok := w.(http.Flusher)
+    if !ok {
+        return nil, ErrFlushNotSupported
+    }
+
+    // A Content-Length would turn chunking off, so make sure there is none
+    w.Header().Del("Content-Length")
+    w.Header().Set("Transfer-Encoding", "chunked")
+    w.Header().Set("X-Content-Type-Options", "nosniff")
+
+    return &ChunkedWriter{w: w, flusher: flusher}, nil
+}
+
+// WriteChunk writes p and flushes it straight away. If the client has
+// disconnected, the error wraps ErrClientGone.
+func (c *ChunkedWriter) WriteChunk(p []byte) error {
+    if len(p) == 0 {
+        return nil // an empty chunk would end the response
+    }
+    if _, err := c.w.Write(p); err != nil {
+        return clientGone(err)
+    }
+    c.flusher.Flush()
+    return nil
+}
+
+// Write makes ChunkedWriter an io.Writer, for fmt.Fprintf and friends.
+func (c *ChunkedWriter) Write(p []byte) (int, error) {
+    if err := c.WriteChunk(p); err != nil {
+        return 0, err
+    }
+    return len(p), nil
+}
+
+Using it for the output of a running job:
+
+func buildLog(w http.ResponseWriter, r *http.Request) {
+    id, ok := PathParam(w, r, "id") // http-handler-helpers.go
+    if !ok {
+        return
+    }
+    w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+    cw, err := NewChunkedWriter(w)
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusInternalServerError)
+        return
+    }
+
+    for line := range buildOutput(r.Context(), id) {
+        if err := cw.WriteChunk([]byte(line + "\n")); err != nil {
+            return // the client went away
+        }
+    }
+}
+
+With curl -N you can watch the lines come in one by one.
+
+What's happening in that code?
+Asserting http.Flusher once: The check happens in NewChunkedWriter, before anything is sent, so the handler can still answer with a proper error. Without it, a write through a wrapper that can't flush would quietly buffer the whole response, and the "progressive" output would arrive all at once at the end.
+
+The Transfer-Encoding header: net/http would pick chunked encoding on its own, but only as long as nobody sets Content-Length. Deleting it and setting Transfer-Encoding makes the intent explicit. The server sees the header, uses chunked encoding, and doesn't send it twice.
+
+No empty chunks: A chunk of length zero is how chunked encoding marks the end of the body, so WriteChunk ignores empty slices. (net/http wouldn't actually send one for an empty Write, but the rule is worth keeping in mind.)
+
+nosniff: Browsers hold back the first bytes of a response to guess its type if they aren't sure. X-Content-Type-Options: nosniff tells them to trust the Content-Type, so the first lines show up immediately. It also stops net/http from guessing the type, so always set Content-Type yourself.
+
+Important: Chunked encoding only exists in HTTP/1.1. Over HTTP/2 the server drops the Transfer-Encoding header and uses HTTP/2's own framing, which streams just as well, so the same handler works with both. What the header can't fix is a proxy in between that buffers responses: nginx, for example, needs "X-Accel-Buffering: no" (or proxy_buffering off) before it passes chunks through as they arrive. If the middleware chain wraps the ResponseWriter, the wrapper needs a Flush method (like the responseRecorder in http-middleware.go has), or NewChunkedWriter reports ErrFlushNotSupported.
+
+
+7. When the Client Hangs Up (ErrClientGone)
+-------------------------------------------
+People close tabs, phones lose their signal, a curl gets a Ctrl-C. For a normal response nobody notices, but a streamed export that takes a minute is likely to be writing when it happens. The next write fails with "write: broken pipe" or "connection reset by peer", the export gives up as designed, and the handler logs an error. On a busy server that's a steady stream of error lines about something that isn't an error at all, and they bury the real failures.
+
+The streaming helpers now recognise a disconnect and say so with their own error:
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "syscall"
+)
+
+// ErrClientGone means the client disconnected before the response was
+// complete. There is nobody left to send an error to, so handlers can
+// ignore it.
+var ErrClientGone = errors.New("client disconnected")
+
+// clientGone wraps err in ErrClientGone if it is one of the ways a
+// disconnect shows up: a write to a closed connection, or the request's
+// context being cancelled.
+func clientGone(err error) error {
+    if err == nil || errors.Is(err, ErrClientGone) {
+        return err
+    }
+    if errors.Is(err, syscall.EPIPE) || errors.Is(err, syscall.ECONNRESET) || errors.Is(err, context.Canceled) {
+        return fmt.Errorf("%w: %w", ErrClientGone, err)
+    }
+    return err
+}
+
+StreamExport (section 3), MultipartWriter.WriteFile (section 4) and ChunkedWriter.WriteChunk (section 6) pass their errors through clientGone before returning them. The handler from section 3 now only logs real failures:
+
+if err != nil && !errors.Is(err, ErrClientGone) {
+    log.Printf("export orders: %v", err)
+}
+
+What's happening in that code?
+Three ways to notice: If the client closes the connection cleanly, the next write fails with EPIPE (broken pipe); if its side resets the connection, with ECONNRESET. And net/http cancels r.Context() as soon as it sees the connection close, so a producer waiting on the database gets context.Canceled before it writes anything. All three mean the same thing here.
+
+errors.Is through the layers: The write error is a *net.OpError around an *os.SyscallError around the syscall.Errno. errors.Is unwraps all of them, so there is no need to compare error strings, which also differ between operating systems.
+
+Wrapping twice: fmt.Errorf with two %w (Go 1.20 and later) gives an error that matches both ErrClientGone and the original error. The handler checks errors.Is(err, ErrClientGone), and the log line, if you still want one at debug level, keeps the original message.
+
+Only where it's certain: clientGone is used in the helpers that write to the client or run with the request's context. CopyContext itself doesn't use it, because it may be copying into a file or another service, where a broken pipe is a real error.
+
+Important: Treating context.Canceled as "client gone" is only right for a context that comes from r.Context(). If you cancel a derived context yourself (a timeout for the whole export, say), that would be reported as a disconnect too, so use context.WithTimeout, whose error is context.DeadlineExceeded and stays a normal error. Nothing here makes a half-written file complete: a client that reconnects has to start the export again.
@@ -0,0 +1,352 @@
+Testing Helpers
+===============
+
+Go has testing built in. A file ending in _test.go holds functions named TestXxx(t *testing.T), and go test ./... runs them all:
+
+func TestAdd(t *testing.T) {
+    if got := Add(2, 3); got != 5 {
+        t.Errorf("Add(2, 3) = %d, want 5", got)
+    }
+}
+
+t.Errorf reports a failure and keeps going, while t.Fatalf stops the test. Helpers that take a testing.TB work in both tests and benchmarks. This file collects helpers that make the code in these notes easy to test.
+
+
+1. A Fake Clock for Time-Dependent Code
+---------------------------------------
+Code that waits is painful to test. If a cache entry expires after a minute, the honest test sleeps for a minute. If you shorten the TTL to 10ms and sleep for 20ms, the test passes on your laptop and fails now and then on a busy CI machine.
+
+The way out is to stop calling the time package directly. The helpers ask a Clock for the time instead. In production that is the real clock. In tests it is a fake clock that only moves when the test says so, instantly and in exactly the order the test wants.
+
+import (
+    "sort"
+    "sync"
+    "time"
+)
+
+// Clock is the part of the time package that time-dependent helpers use.
+type Clock interface {
+    Now() time.Time
+    After(d time.Duration) <-chan time.Time
+    NewTicker(d time.Duration) Ticker
+}
+
+// Ticker mirrors *time.Ticker, with C as a method so fakes can provide it.
+type Ticker interface {
+    C() <-chan time.Time
+    Stop()
+}
+
+// Real returns a Clock backed by the time package.
+func Real() Clock { return realClock{} }
+
+type realClock struct{}
+
+func (realClock) Now() time.Time                         { return time.Now() }
+func (realClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+func (realClock) NewTicker(d time.Duration) Ticker       { return realTicker{time.NewTicker(d)} }
+
+type realTicker struct{ t *time.Ticker }
+
+func (r realTicker) C() <-chan time.Time { return r.t.C }
+func (r realTicker) Stop()               { r.t.Stop() }
+
+// Fake is a Clock that only moves when you call Advance.
+type Fake struct {
+    mu      sync.Mutex
+    now     time.Time
+    waiters []*fakeWaiter
+}
+
+type fakeWaiter struct {
+    at      time.Time
+    period  time.Duration // 0 for After, the interval for tickers
+    ch      chan time.Time
+    stopped bool
+}
+
+func NewFake(start time.Time) *Fake {
+    return &Fake{now: start}
+}
+
+func (f *Fake) Now() time.Time {
+    f.mu.Lock()
+    defer f.mu.Unlock()
+    return f.now
+}
+
+func (f *Fake) After(d time.Duration) <-chan time.Time {
+    return f.add(d, 0).ch
+}
+
+func (f *Fake) NewTicker(d time.Duration) Ticker {
+    if d <= 0 {
+        panic("clock: non-positive interval for NewTicker")
+    }
+    return &fakeTicker{f: f, w: f.add(d, d)}
+}
+
+// Advance moves the clock forward by d and fires every After and tick that
+// became due, in time order.
+func (f *Fake) Advance(d time.Duration) {
+    f.mu.Lock()
+    defer f.mu.Unlock()
+
+    target := f.now.Add(d)
+    for {
+        sort.SliceStable(f.waiters, func(i, j int) bool { return f.waiters[i].at.Before(f.waiters[j].at) })
+        if len(f.waiters) == 0 || f.waiters[0].at.After(target) {
+            break
+        }
+
+        w := f.waiters[0]
+        f.now = w.at
+        select {
+        case w.ch <- w.at:
+        default: // like a real ticker, drop the tick if nobody picked up the last one
+        }
+
+        if w.period > 0 && !w.stopped {
+            w.at = w.at.Add(w.period)
+        } else {
+            f.waiters = f.waiters[1:]
+        }
+    }
+    f.now = target
+}
+
+// Waiters reports how many Afters and tickers are pending. Tests use it to
+// wait until the code under test has started waiting before calling Advance.
+func (f *Fake) Waiters() int {
+    f.mu.Lock()
+    defer f.mu.Unlock()
+    return len(f.waiters)
+}
+
+func (f *Fake) add(d, period time.Duration) *fakeWaiter {
+    f.mu.Lock()
+    defer f.mu.Unlock()
+    w := &fakeWaiter{at: f.now.Add(d), period: period, ch: make(chan time.Time, 1)}
+    f.waiters = append(f.waiters, w)
+    return w
+}
+
+type fakeTicker struct {
+    f *Fake
+    w *fakeWaiter
+}
+
+func (t *fakeTicker) C() <-chan time.Time { return t.w.ch }
+
+func (t *fakeTicker) Stop() {
+    t.f.mu.Lock()
+    defer t.f.mu.Unlock()
+    t.w.stopped = true
+    for i, w := range t.f.waiters {
+        if w == t.w {
+            t.f.waiters = append(t.f.waiters[:i], t.f.waiters[i+1:]...)
+            break
+        }
+    }
+}
+
+The helpers that wait take the clock as an option:
+
+- Debounce (concurrency-patterns.go) takes WithClock(...) for the quiet period it waits for.
+- NewRateLimiter (concurrency-patterns.go) takes WithClock(...) to refill its tokens and to time Wait.
+- MemoizeWith (generics.go) uses MemoOptions.Clock for its TTL.
+- OpenWithRetry (database-recipes.go) takes WithClock(...) for the delay between attempts.
+
+Testing a TTL without sleeping:
+
+func TestMemoizeTTL(t *testing.T) {
+    clk := clock.NewFake(time.Date(2026, 10, 14, 9, 0, 0, 0, time.UTC))
+    calls := 0
+    get := MemoizeWith(func(k string) (int, error) {
+        calls++
+        return calls, nil
+    }, MemoOptions{TTL: time.Minute, Clock: clk})
+
+    get("a")
+    clk.Advance(59 * time.Second)
+    get("a") // still cached
+    clk.Advance(2 * time.Second)
+    get("a") // expired, calls the function again
+
+    if calls != 2 {
+        t.Fatalf("function called %d times, want 2", calls)
+    }
+}
+
+Testing a rate limiter the same way:
+
+func TestRateLimiterRefill(t *testing.T) {
+    clk := clock.NewFake(time.Date(2026, 10, 14, 9, 0, 0, 0, time.UTC))
+    limiter := NewRateLimiter(10, 2, WithClock(clk))
+
+    if !limiter.Allow() || !limiter.Allow() {
+        t.Fatal("a full bucket should allow burst calls")
+    }
+    if limiter.Allow() {
+        t.Fatal("empty bucket allowed a call")
+    }
+    clk.Advance(100 * time.Millisecond) // one token at 10 per second
+    if !limiter.Allow() {
+        t.Fatal("no token after 100ms")
+    }
+}
+
+What's happening in that code?
+The Ticker interface: *time.Ticker exposes its channel as a field, C. An interface can't have fields, so our Ticker has a C() method instead, and realTicker adapts the real one.
+
+Advance fires in order: Advance(5 * time.Second) doesn't jump straight to the end. It walks through every After and tick that falls inside those 5 seconds, oldest first, and sets Now() to each one's time as it goes. Code that reads Now() while reacting to a tick sees the same time it would in real life.
+
+Buffered channels: Each waiter's channel holds one value, so Advance never blocks on code that isn't currently listening. A ticker whose last tick was never read drops the new one, just like time.Ticker.
+
+Waiters(): A goroutine might not have called After yet when the test calls Advance, and the tick would be missed. Tests can loop until clk.Waiters() reports that the code has started waiting.
+
+Important: A new helper that waits, like a retry backoff, should take WithClock the same way Debounce and NewRateLimiter do. Anything that calls time.Now() or time.After() directly, deep inside, can only be tested by sleeping.
+
+
+2. A Real Server for Integration Tests (NewTestServer)
+------------------------------------------------------
+httptest.NewRecorder is enough to test one handler. To test the whole stack (Router, middleware, JSON encoding, status codes), it is better to make real HTTP requests. httptest.NewServer starts a server on a free local port, and NewTestServer puts the Client from http-client.go in front of it and cleans both up when the test ends.
+
+import (
+    "log/slog"
+    "net/http"
+    "net/http/httptest"
+    "strings"
+    "testing"
+)
+
+// NewTestServer serves h on a local port for the length of the test and
+// returns a Client pointed at it. Requests are logged with t.Log, so they
+// show up next to the failures in go test -v.
+func NewTestServer(t testing.TB, h http.Handler) (*httptest.Server, *Client) {
+    t.Helper()
+
+    logger := slog.New(slog.NewTextHandler(testWriter{t}, &slog.HandlerOptions{Level: slog.LevelDebug}))
+    srv := httptest.NewServer(LoggerWith(logger)(h))
+    client := NewClient(srv.URL, WithHTTPClient(srv.Client()))
+
+    // Cleanups run last-in first-out: the client goes first, then the server
+    // waits for in-flight requests and stops
+    t.Cleanup(srv.Close)
+    t.Cleanup(client.Close)
+    return srv, client
+}
+
+// testWriter turns each log line into a t.Log call.
+type testWriter struct {
+    t testing.TB
+}
+
+func (w testWriter) Write(p []byte) (int, error) {
+    w.t.Log(strings.TrimSuffix(string(p), "\n"))
+    return len(p), nil
+}
+
+Using it:
+
+func TestGetUser(t *testing.T) {
+    router := NewRouter()
+    router.HandleFunc("GET", "/users/{id}", getUser)
+
+    _, client := NewTestServer(t, router)
+
+    var user User
+    if err := client.Get(context.Background(), "/users/1", &user); err != nil {
+        t.Fatal(err)
+    }
+    if user.Name != "Ann" {
+        t.Errorf("name = %q, want Ann", user.Name)
+    }
+
+    err := client.Get(context.Background(), "/users/999", &user)
+    var statusErr *StatusError
+    if !errors.As(err, &statusErr) || statusErr.Code != http.StatusNotFound {
+        t.Errorf("err = %v, want 404", err)
+    }
+}
+
+With go test -v, every request shows up in the test output:
+
+=== RUN   TestGetUser
+    handler.go:321: time=... level=INFO msg=request method=GET path=/users/1 status=200 bytes=38 duration=41µs
+
+What's happening in that code?
+LoggerWith: The handler is wrapped in the same access logger as in http-middleware.go, but it logs through a slog handler that writes to t.Log. Output from a passing test stays hidden unless you run go test -v, and a failing test shows the requests that led to it.
+
+srv.Client(): The http.Client that httptest prepared for this server. For NewTLSServer it also trusts the test certificate, so the same test works with HTTPS.
+
+t.Cleanup: Registered functions run after the test, also when it fails with t.Fatal. They run in reverse order, so the client's connections are closed first and then srv.Close waits for running requests and shuts down.
+
+Not TestServer: go test treats every function in a _test.go file whose name starts with Test as a test, and refuses to run one that doesn't take just a *testing.T ("wrong signature for TestServer"). Helpers that live next to the tests need another name.
+
+Important: Pass NewTestServer the same handler chain main uses (the Router plus every middleware that wraps it there), otherwise the test skips exactly the code that tends to break. The log lines point at handler.go instead of your test, because slog makes the t.Log call, not your code.
+
+
+3. Checking a JSON Handler in One Line (CaptureJSON)
+----------------------------------------------------
+Most handler tests are the same four steps: make a ResponseRecorder, call the handler, decode the body, check for decode errors. Only the last part, the assertions, is different each time. CaptureJSON does the first four:
+
+import (
+    "encoding/json"
+    "net/http"
+    "net/http/httptest"
+    "testing"
+)
+
+// CaptureJSON serves req with h and decodes the JSON response into a T. The
+// test fails if the body isn't valid JSON for T.
+func CaptureJSON[T any](t testing.TB, h http.HandlerFunc, req *http.Request) (int, T) {
+    t.Helper()
+
+    rec := httptest.NewRecorder()
+    h(rec, req)
+
+    var v T
+    if err := json.Unmarshal(rec.Body.Bytes(), &v); err != nil {
+        t.Fatalf("%s %s: status %d, decoding body as %T: %v\nbody: %s",
+            req.Method, req.URL, rec.Code, v, err, rec.Body.String())
+    }
+    return rec.Code, v
+}
+
+Using it, with getUsers from connecting-to-databases.go:
+
+func TestGetUsers(t *testing.T) {
+    req := httptest.NewRequest("GET", "/users", nil)
+    status, page := CaptureJSON[usersPage](t, getUsers, req)
+
+    if status != http.StatusOK {
+        t.Fatalf("status = %d, want 200", status)
+    }
+    if len(page.Users) != 2 || page.Users[0].Name != "Ann" || page.Total != 2 {
+        t.Errorf("page = %+v", page)
+    }
+}
+
+An error response can be decoded with a different T, here the shape WriteError (Error-handling.go) sends:
+
+type errorBody struct {
+    Error struct {
+        Code    ErrorCode `json:"code"`
+        Message string    `json:"message"`
+    } `json:"error"`
+}
+
+req := httptest.NewRequest("GET", "/users/999", nil)
+req.SetPathValue("id", "999")
+status, body := CaptureJSON[errorBody](t, getUser, req)
+// status == 404, body.Error.Code == CodeNotFound
+
+What's happening in that code?
+The type parameter: page comes back as a usersPage, not as any or raw bytes, so the assertions use fields directly and the compiler checks them.
+
+t.Helper(): Marks CaptureJSON as a helper, so a failure is reported at the line in your test that called it, not inside CaptureJSON.
+
+A useful failure message: When decoding fails, the message includes the status and the raw body. A handler that answered with http.Error's plain text instead of JSON shows up as "status 500 ... body: sql: no rows in result set" instead of just "invalid character".
+
+Important: CaptureJSON calls the handler directly, without a Router, so r.PathValue("id") is empty. For handlers that read path values, set them on the request first with req.SetPathValue("id", "999"), or go through the Router with NewTestServer (section 2). It reads the whole body, so it isn't meant for streaming responses like SSE.
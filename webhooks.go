@@ -0,0 +1,149 @@
+Sending and Receiving Webhooks
+==============================
+
+A webhook is an HTTP request one server sends to another when something happens: "payment succeeded", "book created". Your program either sends them (you POST to a URL your customer gave you) or receives them (you expose a URL and someone else POSTs to it).
+
+Either way, the receiver has a problem. Anyone on the internet can POST to that URL. How does it know the request really came from you and wasn't changed on the way?
+
+
+1. Signing and Verifying Webhook Requests
+-----------------------------------------
+The standard answer is an HMAC signature. Both sides share a secret. The sender computes HMAC-SHA256(secret, timestamp + "." + body) and sends it in a header. The receiver computes the same value with its copy of the secret and compares. Without the secret nobody can produce a valid signature, and changing even one byte of the body breaks it.
+
+The timestamp is part of the signed data for a reason. Without it, an attacker who captured one valid request could send it again tomorrow (a "replay attack"). The receiver rejects requests whose timestamp is too far from its own clock.
+
+import (
+    "bytes"
+    "crypto/hmac"
+    "crypto/sha256"
+    "encoding/hex"
+    "errors"
+    "fmt"
+    "io"
+    "net/http"
+    "strconv"
+    "strings"
+    "time"
+)
+
+var (
+    ErrSignatureMissing  = errors.New("webhook: missing signature or timestamp")
+    ErrSignatureExpired  = errors.New("webhook: timestamp outside the allowed window")
+    ErrSignatureMismatch = errors.New("webhook: signature does not match")
+    ErrBodyTooLarge      = errors.New("webhook: body larger than 1MB")
+)
+
+const maxWebhookBody = 1 << 20 // 1MB
+
+// SignRequest adds X-Timestamp and X-Signature headers to req. The signature
+// is an HMAC-SHA256 of "<timestamp>.<body>".
+func SignRequest(req *http.Request, secret []byte) error {
+    var body []byte
+    if req.Body != nil {
+        var err error
+        if body, err = io.ReadAll(req.Body); err != nil {
+            return fmt.Errorf("webhook: read body: %w", err)
+        }
+        req.Body.Close()
+        // Put the body back so the request can still be sent
+        req.Body = io.NopCloser(bytes.NewReader(body))
+        req.GetBody = func() (io.ReadCloser, error) {
+            return io.NopCloser(bytes.NewReader(body)), nil
+        }
+        req.ContentLength = int64(len(body))
+    }
+
+    timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+    req.Header.Set("X-Timestamp", timestamp)
+    req.Header.Set("X-Signature", "sha256="+sign(secret, timestamp, body))
+    return nil
+}
+
+// VerifySignature checks the headers set by SignRequest. Requests older (or
+// newer) than tolerance are rejected so a captured request can't be replayed
+// later. The body is left readable for the handler.
+func VerifySignature(r *http.Request, secret []byte, tolerance time.Duration) error {
+    timestamp := r.Header.Get("X-Timestamp")
+    signature, ok := strings.CutPrefix(r.Header.Get("X-Signature"), "sha256=")
+    if timestamp == "" || !ok {
+        return ErrSignatureMissing
+    }
+
+    unix, err := strconv.ParseInt(timestamp, 10, 64)
+    if err != nil {
+        return ErrSignatureMissing
+    }
+    if age := time.Since(time.Unix(unix, 0)); age > tolerance || age < -tolerance {
+        return ErrSignatureExpired
+    }
+
+    // One byte more than allowed tells a body of exactly 1MB from a longer one
+    body, err := io.ReadAll(io.LimitReader(r.Body, maxWebhookBody+1))
+    if err != nil {
+        return fmt.Errorf("webhook: read body: %w", err)
+    }
+    if len(body) > maxWebhookBody {
+        return ErrBodyTooLarge
+    }
+    r.Body = io.NopCloser(bytes.NewReader(body))
+
+    expected := sign(secret, timestamp, body)
+    // hmac.Equal takes the same time whether the first or the last byte differs
+    if !hmac.Equal([]byte(signature), []byte(expected)) {
+        return ErrSignatureMismatch
+    }
+    return nil
+}
+
+func sign(secret []byte, timestamp string, body []byte) string {
+    mac := hmac.New(sha256.New, secret)
+    mac.Write([]byte(timestamp))
+    mac.Write([]byte("."))
+    mac.Write(body)
+    return hex.EncodeToString(mac.Sum(nil))
+}
+
+Sending (use MarshalCanonical from json-recipes.go, so the bytes you sign are exactly the bytes you send):
+
+body, err := MarshalCanonical(Event{Type: "book.created", ID: 7})
+if err != nil {
+    return err
+}
+req, err := http.NewRequest(http.MethodPost, customerURL, bytes.NewReader(body))
+if err != nil {
+    return err
+}
+req.Header.Set("Content-Type", "application/json")
+if err := SignRequest(req, secret); err != nil {
+    return err
+}
+resp, err := http.DefaultClient.Do(req)
+
+Receiving:
+
+func webhookHandler(w http.ResponseWriter, r *http.Request) {
+    err := VerifySignature(r, secret, 5*time.Minute)
+    if errors.Is(err, ErrBodyTooLarge) {
+        http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+        return
+    }
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusUnauthorized)
+        return
+    }
+
+    var event Event
+    json.NewDecoder(r.Body).Decode(&event) // the body is still readable
+    ...
+}
+
+What's happening in that code?
+Reading and restoring the body: A request body can only be read once. SignRequest and VerifySignature read it to compute the HMAC, then replace it with a fresh reader over the same bytes. GetBody lets net/http resend the body if it needs to follow a redirect.
+
+hmac.Equal instead of ==: A normal string comparison stops at the first byte that differs, so a wrong guess fails slightly faster the earlier it goes wrong. By measuring those tiny differences an attacker can guess a signature one byte at a time. hmac.Equal always takes the same time.
+
+Specific errors: Each failure has its own error value, so the receiver can log why a request was rejected while still answering a plain 401. ErrBodyTooLarge is the exception that gets its own status, 413: the sender did nothing wrong with the signature, the payload is just bigger than this receiver accepts.
+
+The size limit: VerifySignature reads at most 1MB plus one byte. If that extra byte arrives, the body is too large and the request is rejected outright. Cutting the body off at 1MB instead would make the signature fail for a reason that has nothing to do with the secret, and worse, a handler that ignored the error would see half a JSON document.
+
+Important: Keep the secret out of your source code (read it with MustEnv from configuration.go) and use a different secret for each customer, so leaking one doesn't expose them all.
@@ -0,0 +1,436 @@
+Worker Pools
+============
+
+The fast downloader in goroutines.go starts one goroutine per site. With three sites that is perfect. With 10,000 it means 10,000 simultaneous connections, which is more than most servers (and your own network) will put up with. A worker pool fixes the number of goroutines doing the work, say 20, and lets every other task wait its turn in a queue.
+
+
+1. A Basic Worker Pool
+----------------------
+The pool is a channel of tasks plus a fixed number of goroutines that take tasks from it. It takes the same WithLogger option as the other helpers (see logging.go), and two options of its own that fill the worker-pool fields of config:
+
+import "time"
+
+// WithDeadLetter sets fn to receive every task that panicked or failed on
+// its last attempt.
+func WithDeadLetter(fn func(task TaskInfo, err error)) Option {
+    return func(c *config) { c.deadLetter = fn }
+}
+
+// WithRetries runs a failing SubmitTask task up to attempts times in total,
+// waiting delay before the first retry and twice as long before each next one.
+func WithRetries(attempts int, delay time.Duration) Option {
+    return func(c *config) { c.attempts, c.retryDelay = attempts, delay }
+}
+
+import (
+    "errors"
+    "fmt"
+    "sync"
+    "time"
+)
+
+var ErrPoolClosed = errors.New("worker pool: closed")
+
+// WorkerPool runs submitted tasks on a fixed number of goroutines.
+type WorkerPool struct {
+    cfg   config
+    tasks chan queuedTask
+    wg    sync.WaitGroup
+
+    mu     sync.RWMutex
+    closed bool
+}
+
+// queuedTask is a task in the queue, with what the dead letter function
+// should be told about it if it panics.
+type queuedTask struct {
+    info TaskInfo
+    fn   func()
+}
+
+// NewWorkerPool starts workers goroutines. Up to queueSize tasks can wait
+// for a free worker before Submit starts to block.
+func NewWorkerPool(workers, queueSize int, opts ...Option) *WorkerPool {
+    cfg := newConfig(opts)
+    cfg.attempts = max(cfg.attempts, 1)
+    p := &WorkerPool{cfg: cfg, tasks: make(chan queuedTask, queueSize)}
+    for range workers {
+        p.wg.Add(1)
+        go p.worker()
+    }
+    return p
+}
+
+// Submit queues task, blocking while the queue is full.
+func (p *WorkerPool) Submit(task func()) error {
+    return p.submit(TaskInfo{Submitted: time.Now()}, task)
+}
+
+func (p *WorkerPool) submit(info TaskInfo, task func()) error {
+    p.mu.RLock()
+    defer p.mu.RUnlock()
+    if p.closed {
+        return ErrPoolClosed
+    }
+    p.tasks <- queuedTask{info: info, fn: task}
+    return nil
+}
+
+// Close stops accepting tasks and waits for the queued ones to finish.
+func (p *WorkerPool) Close() {
+    p.mu.Lock()
+    if !p.closed {
+        p.closed = true
+        close(p.tasks)
+    }
+    p.mu.Unlock()
+    p.wg.Wait()
+}
+
+func (p *WorkerPool) worker() {
+    defer p.wg.Done()
+    for task := range p.tasks {
+        p.run(task)
+    }
+}
+
+// run keeps one panicking task from killing the worker (and the program).
+func (p *WorkerPool) run(task queuedTask) {
+    defer func() {
+        if r := recover(); r != nil {
+            info := task.info
+            info.Attempts = 1
+            p.cfg.logger.Error("worker pool: task panicked", "task", info.Name, "panic", r)
+            p.deadLetter(info, fmt.Errorf("task panicked: %v", r))
+        }
+    }()
+    task.fn()
+}
+
+Using it:
+
+pool := NewWorkerPool(20, 100) // 20 workers, 100 tasks may wait
+for _, url := range urls {
+    pool.Submit(func() {
+        fetch(ctx, url) // goroutines.go
+    })
+}
+pool.Close() // waits until every task has run
+
+What's happening in that code?
+for range workers: Go 1.22 lets you range over an integer, which simply runs the loop that many times.
+
+The tasks channel is the queue: Its buffer (queueSize) holds tasks that are waiting. When it is full, Submit blocks until a worker takes one, which slows fast producers down to the speed of the workers (that's backpressure).
+
+range p.tasks: Each worker loops until the channel is closed and empty. That is how Close lets the queued work finish before the workers exit.
+
+recover in run: A panic in a normal goroutine crashes the whole program. run catches it, logs it, and the worker moves on to the next task. The task also goes to the dead letter function (section 3) with the TaskInfo it was queued with, so the dead letter sees when it was submitted, not an empty record.
+
+
+2. Getting Results Back (SubmitResult)
+--------------------------------------
+Submit is fire-and-forget. To get an answer back you would have to create a channel, capture it in the task, and remember to send on it even when things go wrong. SubmitResult does that for you and hands back a channel that is guaranteed to receive exactly one Result:
+
+import "fmt"
+
+type Result[T any] struct {
+    Value T
+    Err   error
+}
+
+// SubmitResult runs task on the pool and delivers its result on the
+// returned channel, which always receives exactly one value.
+func SubmitResult[T any](p *WorkerPool, task func() (T, error)) <-chan Result[T] {
+    ch := make(chan Result[T], 1)
+
+    err := p.Submit(func() {
+        defer func() {
+            // Without this, a panic would leave the caller waiting forever
+            if r := recover(); r != nil {
+                ch <- Result[T]{Err: fmt.Errorf("task panicked: %v", r)}
+            }
+        }()
+        v, err := task()
+        ch <- Result[T]{Value: v, Err: err}
+    })
+    if err != nil {
+        ch <- Result[T]{Err: err}
+    }
+    return ch
+}
+
+The fast downloader, through the pool:
+
+pool := NewWorkerPool(3, 10)
+defer pool.Close()
+
+var pending []<-chan Result[string]
+for _, site := range []string{"Google.com", "Amazon.com", "Github.com"} {
+    pending = append(pending, SubmitResult(pool, func() (string, error) {
+        time.Sleep(2 * time.Second) // simulate a slow download
+        return site + " is done!", nil
+    }))
+}
+
+for _, ch := range pending {
+    r := <-ch
+    if r.Err != nil {
+        fmt.Println("failed:", r.Err)
+        continue
+    }
+    fmt.Println(r.Value)
+}
+
+What's happening in that code?
+Result[T]: A generic struct, so the value keeps its real type (string here) instead of any, and the error travels next to it.
+
+A buffer of one: The task can always send its result, even if nobody is reading yet. A task never blocks a worker waiting for its caller.
+
+Exactly one value, always: The task's own recover turns a panic into an error Result, and a Submit on a closed pool puts ErrPoolClosed on the channel straight away. Whatever happens, <-ch never blocks forever.
+
+Important: Results come back in the order you read the channels, not the order the tasks finish. Here that means submission order. To handle whichever finishes first, read them with select, or have the tasks send into one shared channel.
+
+
+3. Retries and a Dead Letter for Failed Tasks
+---------------------------------------------
+Downloads fail. Some failures go away on a second try (a timeout, a 503), and some don't (a 404). Either way, a task that finally gives up shouldn't just disappear into a log line. SubmitTask runs a task that returns an error, retries it as set by WithRetries, and hands the ones that still fail to a dead letter function, named after the "dead letter office" where undeliverable mail goes.
+
+The WithDeadLetter and WithRetries options are in section 1, and run() there also sends panicking Submit tasks to the dead letter. The rest:
+
+import (
+    "fmt"
+    "time"
+)
+
+// TaskInfo describes a task handed to the dead letter function.
+type TaskInfo struct {
+    Name      string // as passed to SubmitTask, e.g. the URL being downloaded
+    Submitted time.Time
+    Attempts  int
+}
+
+// SubmitTask queues a task that can fail. A failing task is retried as set
+// by WithRetries; if the last attempt fails too, or the task panics, it goes
+// to the dead letter function.
+func (p *WorkerPool) SubmitTask(name string, task func() error) error {
+    info := TaskInfo{Name: name, Submitted: time.Now()}
+    return p.submit(info, func() {
+        delay := p.cfg.retryDelay
+        for attempt := 1; ; attempt++ {
+            info.Attempts = attempt
+            panicked, err := callTask(task)
+            if err == nil {
+                return
+            }
+            if panicked || attempt >= p.cfg.attempts {
+                p.cfg.logger.Warn("worker pool: task failed",
+                    "task", info.Name, "attempts", info.Attempts, "err", err)
+                p.deadLetter(info, err)
+                return
+            }
+            time.Sleep(delay) // the worker waits too, which slows the whole pool down a little
+            delay *= 2
+        }
+    })
+}
+
+// callTask runs task and turns a panic into an error. A panic is a bug, so
+// it isn't worth retrying.
+func callTask(task func() error) (panicked bool, err error) {
+    defer func() {
+        if r := recover(); r != nil {
+            panicked, err = true, fmt.Errorf("task panicked: %v", r)
+        }
+    }()
+    return false, task()
+}
+
+func (p *WorkerPool) deadLetter(info TaskInfo, err error) {
+    if p.cfg.deadLetter != nil {
+        p.cfg.deadLetter(info, err)
+    }
+}
+
+Using it:
+
+pool := NewWorkerPool(20, 100,
+    WithRetries(3, time.Second), // try, wait 1s, try, wait 2s, try
+    WithDeadLetter(func(task TaskInfo, err error) {
+        // Keep it for later inspection (or a manual re-run)
+        if _, dbErr := db.Exec("INSERT INTO failed_downloads (url, attempts, error) VALUES (?, ?, ?)",
+            task.Name, task.Attempts, err.Error()); dbErr != nil {
+            log.Printf("dead letter: %v", dbErr)
+        }
+    }),
+)
+
+for _, url := range urls {
+    pool.SubmitTask(url, func() error {
+        _, err := fetch(ctx, url)
+        return err
+    })
+}
+pool.Close()
+
+What's happening in that code?
+TaskInfo: The name is whatever identifies the task for a human (here the URL). Submitted and Attempts tell the operator how long it was tried and how often.
+
+Panics skip the retries: A panic is a bug in the task, and running buggy code three times gives the same result. callTask turns the panic into an error and the task goes to the dead letter at once.
+
+Doubling delay: Retrying immediately usually hits the same overloaded server again. Waiting 1s, then 2s, then 4s gives it room to recover, the same idea as OpenWithRetry in database-recipes.go.
+
+Important: The retry delay runs on the worker, so while a task waits to retry, that worker does nothing else. Keep delays short, or for long backoffs, have the dead letter schedule a new SubmitTask later instead. The dead letter function runs on the worker too, and it must not call Submit on a full queue, or the worker blocks waiting for itself.
+
+
+4. Growing and Shrinking with the Load (AutoScalePool)
+------------------------------------------------------
+NewWorkerPool(20, 100) always runs 20 workers. If the load comes in bursts, say a nightly import that queues 50,000 tasks and then nothing for hours, 20 is either too few for the burst or too many for the quiet time. AutoScalePool starts with a minimum, adds workers while tasks pile up in the queue, and lets the extra ones stop again once there's nothing left for them to do.
+
+The pool's own settings come in a ScaleOptions struct, the way MemoizeWith takes MemoOptions in generics.go. The options from section 1, like WithLogger and WithDeadLetter, still apply to every worker:
+
+import (
+    "sync"
+    "sync/atomic"
+    "time"
+)
+
+// ScaleOptions configures an AutoScalePool. The zero value uses the defaults.
+type ScaleOptions struct {
+    Threshold   int           // add workers while more tasks than this wait; 0 means half the queue
+    IdleTimeout time.Duration // an extra worker without a task for this long stops; 0 means 30s
+}
+
+// scaleInterval is how often an AutoScalePool looks at its queue.
+const scaleInterval = 250 * time.Millisecond
+
+// AutoScalePool is a WorkerPool that adds workers, up to a maximum, while
+// tasks pile up in its queue, and lets the extra ones go again once they
+// have been idle for a while. Submit and SubmitTask work on it as on any
+// WorkerPool; SubmitResult takes its embedded WorkerPool.
+type AutoScalePool struct {
+    *WorkerPool
+    maxWorkers  int
+    threshold   int
+    idleTimeout time.Duration
+    workers     atomic.Int64
+
+    stopOnce   sync.Once
+    stop       chan struct{}
+    scalerDone chan struct{}
+}
+
+// NewAutoScalePool starts minWorkers workers, which always keep running;
+// minWorkers must be at least 1.
+func NewAutoScalePool(minWorkers, maxWorkers, queueSize int, scale ScaleOptions, opts ...Option) *AutoScalePool {
+    if minWorkers < 1 || maxWorkers < minWorkers {
+        panic("auto scale pool: need 1 <= minWorkers <= maxWorkers")
+    }
+    if scale.Threshold <= 0 {
+        scale.Threshold = queueSize / 2
+    }
+    if scale.IdleTimeout <= 0 {
+        scale.IdleTimeout = 30 * time.Second
+    }
+    p := &AutoScalePool{
+        WorkerPool:  NewWorkerPool(minWorkers, queueSize, opts...),
+        maxWorkers:  maxWorkers,
+        threshold:   scale.Threshold,
+        idleTimeout: scale.IdleTimeout,
+        stop:        make(chan struct{}),
+        scalerDone:  make(chan struct{}),
+    }
+    p.workers.Store(int64(minWorkers))
+    go p.scale()
+    return p
+}
+
+// Workers returns the number of workers running right now, e.g. for a
+// metrics gauge.
+func (p *AutoScalePool) Workers() int {
+    return int(p.workers.Load())
+}
+
+// Close stops the scaling, then works like WorkerPool.Close.
+func (p *AutoScalePool) Close() {
+    p.stopOnce.Do(func() { close(p.stop) })
+    <-p.scalerDone // no worker can be added after this
+    p.WorkerPool.Close()
+}
+
+// scale adds one worker per tick while the queue has stayed above the
+// threshold for two ticks in a row.
+func (p *AutoScalePool) scale() {
+    defer close(p.scalerDone)
+    ticker := time.NewTicker(scaleInterval)
+    defer ticker.Stop()
+
+    above := 0
+    for {
+        select {
+        case <-p.stop:
+            return
+        case <-ticker.C:
+        }
+
+        if len(p.tasks) <= p.threshold {
+            above = 0
+            continue
+        }
+        above++
+        if above >= 2 && p.Workers() < p.maxWorkers {
+            p.workers.Add(1)
+            p.wg.Add(1)
+            go p.extraWorker()
+        }
+    }
+}
+
+// extraWorker is a worker that stops after idleTimeout without a task.
+func (p *AutoScalePool) extraWorker() {
+    defer p.wg.Done()
+    defer p.workers.Add(-1)
+
+    idle := time.NewTimer(p.idleTimeout)
+    defer idle.Stop()
+    for {
+        select {
+        case task, ok := <-p.tasks:
+            if !ok {
+                return // closed
+            }
+            p.run(task)
+            idle.Reset(p.idleTimeout)
+        case <-idle.C:
+            return
+        }
+    }
+}
+
+Using it, with the worker count as a gauge (see metrics.go):
+
+pool := NewAutoScalePool(4, 32, 1000, ScaleOptions{IdleTimeout: time.Minute})
+defer pool.Close()
+
+go func() {
+    workers := metrics.Gauge("import.workers")
+    for range time.Tick(10 * time.Second) {
+        workers.Set(float64(pool.Workers()))
+    }
+}()
+
+for _, row := range rows {
+    pool.Submit(func() { importRow(row) })
+}
+
+What's happening in that code?
+Built on WorkerPool: AutoScalePool embeds *WorkerPool, so it has the same queue, the same Submit and SubmitTask, and the same panic handling in run. SubmitResult is a function, not a method, so it takes the embedded pool: SubmitResult(pool.WorkerPool, ...). The min workers are ordinary workers that loop until Close; only the workers added later can stop early.
+
+Queue depth as the signal: len(p.tasks) is the number of tasks waiting in the channel's buffer. It's cheap to read and says exactly what matters: work is arriving faster than the workers finish it. scale looks at it every 250ms and only adds a worker once the queue has stayed above the threshold for two looks in a row, so a short spike doesn't start goroutines that are idle again a moment later.
+
+One worker per tick: A long burst adds a worker every 250ms until the queue drains or maxWorkers is reached, 28 extra workers in about 7 seconds here. Growing step by step gives each new worker a chance to bring the queue down before the next is added.
+
+Retiring idle workers: An extra worker resets its idle timer after every task. When the timer fires, it hasn't had a task for idleTimeout and returns. The deferred workers.Add(-1) keeps Workers() right, and since only extra workers ever stop, the count can't fall below the minimum.
+
+Close in the right order: Close stops the scaler first and waits until it has returned. Only then does WorkerPool.Close close the queue and wait for the workers, so no new worker can be added (and counted in wg) while Wait is already running.
+
+Important: More workers only help if the workers are what's slow. If every task waits on the same database or API, 32 workers mostly means 32 requests queuing on the other side, and if that's a database pool with 10 connections, 22 workers just wait for a connection. Set maxWorkers from what the downstream can take, not from how long the queue gets. When the queue is full, Submit still blocks, as in section 1, so producers slow down once the pool is at its maximum.